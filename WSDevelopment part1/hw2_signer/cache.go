@@ -0,0 +1,133 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// SignerCache memoizes results of a signer function. Concurrent calls with
+// the same argument share one computation, and when limit is positive the
+// least recently used results are evicted once the cache is full. Empty
+// results mean the signer failed and are not kept.
+type SignerCache struct {
+	calc  func(string) string
+	limit int
+
+	mu      sync.Mutex
+	items   map[string]*list.Element
+	order   *list.List
+	pending map[string]*signerCall
+}
+
+type signerCall struct {
+	done chan struct{}
+	val  string
+	// panicked is the value calc panicked with, it is passed to the waiters
+	panicked interface{}
+}
+
+type cacheEntry struct {
+	key string
+	val string
+}
+
+func NewSignerCache(calc func(string) string, limit int) *SignerCache {
+	return &SignerCache{
+		calc:    calc,
+		limit:   limit,
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+		pending: make(map[string]*signerCall),
+	}
+}
+
+func (c *SignerCache) Get(data string) string {
+	key := data + "\x00" + DataSignerSalt
+
+	c.mu.Lock()
+	if elem, ok := c.items[key]; ok {
+		c.order.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*cacheEntry).val
+	}
+	if call, ok := c.pending[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		if call.panicked != nil {
+			panic(call.panicked)
+		}
+		return call.val
+	}
+	call := &signerCall{done: make(chan struct{})}
+	c.pending[key] = call
+	c.mu.Unlock()
+
+	// the waiters are released and the key can be computed again even if
+	// calc panics
+	defer func() {
+		if r := recover(); r != nil {
+			call.panicked = r
+			c.finish(key, call, false)
+			panic(r)
+		}
+	}()
+	call.val = c.calc(data)
+	c.finish(key, call, call.val != "")
+
+	return call.val
+}
+
+// finish removes the pending call, stores its result if ok and releases the
+// waiters
+func (c *SignerCache) finish(key string, call *signerCall, ok bool) {
+	c.mu.Lock()
+	delete(c.pending, key)
+	if ok {
+		c.items[key] = c.order.PushFront(&cacheEntry{key: key, val: call.val})
+		if c.limit > 0 && c.order.Len() > c.limit {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*cacheEntry).key)
+		}
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+func (c *SignerCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+var (
+	crc32Cache *SignerCache
+	md5Cache   *SignerCache
+)
+
+// EnableSignerCache routes DataSignerCrc32 and DataSignerMd5 calls made by
+// the pipeline through memoizing caches holding at most limit results each
+// (no bound if limit <= 0). Results are keyed by the input and DataSignerSalt.
+func EnableSignerCache(limit int) {
+	crc32Cache = NewSignerCache(computeCrc32, limit)
+	md5Cache = NewSignerCache(func(data string) string { return DataSignerMd5(data) }, limit)
+}
+
+func DisableSignerCache() {
+	crc32Cache = nil
+	md5Cache = nil
+}
+
+func signCrc32(data string) string {
+	if crc32Cache != nil {
+		return crc32Cache.Get(data)
+	}
+	return computeCrc32(data)
+}
+
+func signMd5(data string) string {
+	if md5Cache != nil {
+		return md5Cache.Get(data)
+	}
+	return DataSignerMd5(data)
+}
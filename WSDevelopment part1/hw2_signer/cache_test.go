@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignerCacheSingleflight(t *testing.T) {
+	var calls uint32
+	cache := NewSignerCache(func(data string) string {
+		atomic.AddUint32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return "h" + data
+	}, 0)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res := cache.Get("1"); res != "h1" {
+				t.Errorf("unexpected result %q", res)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("concurrent identical calls not deduplicated, calls = %d", calls)
+	}
+	if cache.Get("1"); calls != 1 {
+		t.Errorf("cached value recomputed, calls = %d", calls)
+	}
+}
+
+func TestSignerCacheLRU(t *testing.T) {
+	var calls uint32
+	cache := NewSignerCache(func(data string) string {
+		atomic.AddUint32(&calls, 1)
+		return data
+	}, 2)
+
+	cache.Get("a")
+	cache.Get("b")
+	cache.Get("a") // "b" becomes the least recently used
+	cache.Get("c")
+
+	if cache.Len() != 2 {
+		t.Errorf("cache not bounded, len = %d", cache.Len())
+	}
+	if cache.Get("a"); calls != 3 {
+		t.Errorf("recently used value evicted, calls = %d", calls)
+	}
+	if cache.Get("b"); calls != 4 {
+		t.Errorf("least recently used value not evicted, calls = %d", calls)
+	}
+}
+
+func TestSignerCachePanic(t *testing.T) {
+	var calls uint32
+	started, release := make(chan struct{}), make(chan struct{})
+	cache := NewSignerCache(func(data string) string {
+		if atomic.AddUint32(&calls, 1) == 1 {
+			close(started)
+			<-release
+			panic("calc failed")
+		}
+		return "h" + data
+	}, 0)
+
+	getPanic := func() (p interface{}) {
+		defer func() { p = recover() }()
+		cache.Get("1")
+		return nil
+	}
+	first, waiter := make(chan interface{}), make(chan interface{})
+	go func() { first <- getPanic() }()
+	<-started
+	go func() { waiter <- getPanic() }()
+	// let the waiter find the pending call
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	for name, ch := range map[string]chan interface{}{"caller": first, "waiter": waiter} {
+		select {
+		case p := <-ch:
+			if p != "calc failed" {
+				t.Errorf("%s: expected the calc panic, got %v", name, p)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: blocked after calc panicked", name)
+		}
+	}
+	if res := cache.Get("1"); res != "h1" || calls != 2 {
+		t.Errorf("failed call not retried, got %q after %d calls", res, calls)
+	}
+}
+
+func TestSignerWithCache(t *testing.T) {
+	var crc32Calls uint32
+	origCrc32 := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		atomic.AddUint32(&crc32Calls, 1)
+		return origCrc32(data)
+	}
+	EnableSignerCache(0)
+	defer func() {
+		DataSignerCrc32 = origCrc32
+		DisableSignerCache()
+	}()
+
+	testResult := "NOT_SET"
+	inputData := []int{0, 1, 1}
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, fibNum := range inputData {
+				out <- fibNum
+			}
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			testResult = (<-in).(string)
+		}),
+	)
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542_4958044192186797981418233587017209679042592862002427381542"
+	if testResult != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", testResult, expected)
+	}
+	// the duplicated 1 must not be hashed again
+	if crc32Calls != 2*8 {
+		t.Errorf("unexpected crc32 calls, got %d, expected %d", crc32Calls, 2*8)
+	}
+}
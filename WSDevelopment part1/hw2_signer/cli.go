@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// signerLog receives intermediate hashes when set
+var signerLog *log.Logger
+
+func logHash(format string, args ...interface{}) {
+	if signerLog != nil {
+		signerLog.Printf(format, args...)
+	}
+}
+
+// runSigner signs values read from the files given in args (stdin if there
+// are none or the name is "-") and prints the signature to stdout.
+func runSigner(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("signer", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: signer [-salt salt] [-spec file] [-v] [-stats] [-trace file] [-workers urls [-local-fallback]] [file ...]\n"+
+			"       signer [-salt salt] -worker addr\n\n"+
+			"Values are read one per line or as a JSON array. Every value the last\n"+
+			"stage sends is printed on its own line.\n\n")
+		flags.PrintDefaults()
+	}
+	salt := flags.String("salt", "", "value of DataSignerSalt")
+	specFile := flags.String("spec", "", "sign with the JSON or YAML signature spec in `file`")
+	verbose := flags.Bool("v", false, "print intermediate hashes to stderr")
+	stats := flags.Bool("stats", false, "print per-stage timings to stderr")
+	traceFile := flags.String("trace", "", "write a Chrome trace of the pipeline run to `file`")
+	workerAddr := flags.String("worker", "", "serve crc32 requests on `addr` instead of signing")
+	workers := flags.String("workers", "", "comma-separated worker `urls` to compute crc32 on")
+	fallback := flags.Bool("local-fallback", false, "compute crc32 locally when the workers fail")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *workerAddr != "" {
+		DataSignerSalt = *salt
+		return http.ListenAndServe(*workerAddr, NewWorkerHandler())
+	}
+
+	stages := []job{job(SingleHash), job(MultiHash), job(CombineResults)}
+	if *specFile != "" {
+		var err error
+		if stages, err = loadSpecJobs(*specFile); err != nil {
+			return err
+		}
+	}
+	values, err := readSignerInputs(flags.Args(), stdin)
+	if err != nil {
+		return err
+	}
+
+	prevSalt := DataSignerSalt
+	DataSignerSalt = *salt
+	defer func() { DataSignerSalt = prevSalt }()
+	var pool *WorkerPool
+	if *workers != "" {
+		pool = NewWorkerPool(strings.Split(*workers, ","))
+		pool.LocalFallback = *fallback
+		pool.Start()
+		defer pool.Close()
+		EnableRemoteCrc32(pool)
+		defer DisableRemoteCrc32()
+	}
+	if *verbose {
+		signerLog = log.New(stderr, "", 0)
+		defer func() { signerLog = nil }()
+	}
+
+	results := make([]string, 0, 1)
+	hashSignJobs := []job{
+		job(func(in, out chan interface{}) {
+			for _, val := range values {
+				traceSend(out, val)
+			}
+		}),
+	}
+	hashSignJobs = append(hashSignJobs, stages...)
+	hashSignJobs = append(hashSignJobs, job(func(in, out chan interface{}) {
+		for {
+			val, ok := traceRecv(in)
+			if !ok {
+				break
+			}
+			results = append(results, fmt.Sprint(val))
+		}
+	}))
+
+	var trace *PipelineTrace
+	if *stats || *traceFile != "" {
+		trace = NewPipelineTrace()
+		EnableTracing(trace)
+	}
+	ExecutePipeline(hashSignJobs...)
+	DisableTracing()
+	// the signature is wrong if a worker call failed
+	if pool != nil && pool.Err() != nil {
+		return pool.Err()
+	}
+	for _, res := range results {
+		fmt.Fprintln(stdout, res)
+	}
+
+	if trace == nil {
+		return nil
+	}
+	if *stats {
+		if err := trace.WriteReport(stderr); err != nil {
+			return err
+		}
+	}
+	if *traceFile != "" {
+		f, err := os.Create(*traceFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := trace.WriteChromeTrace(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadSpecJobs(name string) ([]job, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	spec, err := LoadSignatureSpec(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return spec.Jobs()
+}
+
+func readSignerInputs(files []string, stdin io.Reader) ([]interface{}, error) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	values := make([]interface{}, 0)
+	for _, name := range files {
+		var data []byte
+		var err error
+		if name == "-" {
+			data, err = ioutil.ReadAll(stdin)
+		} else {
+			data, err = ioutil.ReadFile(name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		vals, err := parseSignerInput(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		values = append(values, vals...)
+	}
+	return values, nil
+}
+
+func parseSignerInput(data []byte) ([]interface{}, error) {
+	values := make([]interface{}, 0)
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		raw := make([]interface{}, 0)
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		for i, v := range raw {
+			switch v := v.(type) {
+			case json.Number:
+				values = append(values, signerValue(v.String()))
+			case string:
+				values = append(values, v)
+			default:
+				return nil, fmt.Errorf("element %d: unsupported value %v", i, v)
+			}
+		}
+		return values, nil
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, signerValue(line))
+		}
+	}
+	return values, nil
+}
+
+// signerValue keeps integers as ints, the way the pipeline source sends them
+func signerValue(s string) interface{} {
+	if num, err := strconv.Atoi(s); err == nil {
+		return num
+	}
+	return s
+}
+
+func main() {
+	EnableSignerCache(MaxInputDataLen)
+
+	if err := runSigner(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
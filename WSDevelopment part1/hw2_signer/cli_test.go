@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSignerInput(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []interface{}
+	}{
+		{"0\n1\n\n  2 \nabc\n", []interface{}{0, 1, 2, "abc"}},
+		{` [0, 1, "abc", 1.5] `, []interface{}{0, 1, "abc", "1.5"}},
+		{"", []interface{}{}},
+	}
+	for _, c := range cases {
+		values, err := parseSignerInput([]byte(c.input))
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", c.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(values, c.expected) {
+			t.Errorf("wrong values for %q\nGot: %#v\nExpected: %#v", c.input, values, c.expected)
+		}
+	}
+
+	for _, input := range []string{`[0, 1`, `[{"a": 1}]`, `[null]`} {
+		if _, err := parseSignerInput([]byte(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestRunSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jsonFile := filepath.Join(dir, "values.json")
+	if err := ioutil.WriteFile(jsonFile, []byte("[1]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	err = runSigner([]string{"-v", "-", jsonFile}, strings.NewReader("0\n"), stdout, stderr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542\n"
+	if stdout.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", stdout.String(), expected)
+	}
+	for _, line := range []string{
+		"0 SingleHash md5(data) cfcd208495d565ef66e7dff9f98764da\n",
+		"1 SingleHash result 2212294583~709660146\n",
+		"4108050209~502633748 MultiHash: crc32(th+step1)) 5 1025356555\n",
+		"CombineResults " + expected,
+	} {
+		if !strings.Contains(stderr.String(), line) {
+			t.Errorf("verbose output misses %q\nGot: %v", line, stderr.String())
+		}
+	}
+
+	if err := runSigner([]string{filepath.Join(dir, "missing")}, nil, stdout, stderr); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+func TestRunSignerManyValues(t *testing.T) {
+	input := new(bytes.Buffer)
+	values := make([]interface{}, 0, 30)
+	for i := 0; i < 30; i++ {
+		fmt.Fprintln(input, i)
+		values = append(values, i)
+	}
+
+	stdout := new(bytes.Buffer)
+	done := make(chan error, 1)
+	go func() {
+		done <- runSigner(nil, input, stdout, ioutil.Discard)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("signer hangs on 30 values")
+	}
+
+	// the spec stages are a separate implementation of the same recipe
+	if expected := runSpec(t, &DefaultSignatureSpec, values...) + "\n"; stdout.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", stdout.String(), expected)
+	}
+}
+
+func TestRunSignerSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	specFile := filepath.Join(dir, "spec.yaml")
+	spec := "stages:\n  - {stage: single, hashes: [[sha256]], separator: \"\"}\n  - {stage: combine, separator: \",\"}\n"
+	if err := ioutil.WriteFile(specFile, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	if err := runSigner([]string{"-spec", specFile}, strings.NewReader("abc\nabc\n"), stdout, stderr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sum := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if expected := sum + "," + sum + "\n"; stdout.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", stdout.String(), expected)
+	}
+
+	// without combine every value is printed
+	if err := ioutil.WriteFile(specFile, []byte("stages:\n  - stage: single\n    hashes: [[sha256]]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := runSigner([]string{"-spec", specFile}, strings.NewReader("abc\nabc\n"), stdout, stderr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := sum + "\n" + sum + "\n"; stdout.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", stdout.String(), expected)
+	}
+
+	if err := ioutil.WriteFile(specFile, []byte("stages: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSigner([]string{"-spec", specFile}, strings.NewReader("abc\n"), stdout, stderr); err == nil {
+		t.Errorf("expected error for bad spec")
+	}
+	if err := runSigner([]string{"-spec", filepath.Join(dir, "missing")}, strings.NewReader("abc\n"), stdout, stderr); err == nil {
+		t.Errorf("expected error for missing spec")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Remote workers compute DataSignerCrc32 over HTTP:
+//
+//	POST /crc32 {"data": "...", "salt": "..."} -> {"hash": "..."}
+//	GET /health -> 200
+//
+// A worker hashes the data with its own DataSignerSalt and rejects requests
+// made with another salt, so a misconfigured worker cannot return wrong
+// hashes.
+
+type crc32Request struct {
+	Data string `json:"data"`
+	Salt string `json:"salt"`
+}
+
+type crc32Response struct {
+	Hash  string `json:"hash,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func NewWorkerHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/crc32", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(crc32Response{Error: "method not allowed"})
+			return
+		}
+		req := crc32Request{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(crc32Response{Error: err.Error()})
+			return
+		}
+		if req.Salt != DataSignerSalt {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(crc32Response{Error: "salt mismatch"})
+			return
+		}
+		json.NewEncoder(w).Encode(crc32Response{Hash: DataSignerCrc32(req.Data)})
+	})
+	return mux
+}
+
+type remoteWorker struct {
+	url     string
+	healthy bool
+}
+
+// WorkerPool dispatches crc32 calls to remote workers in round-robin order.
+// A worker that fails a call is skipped until a health check succeeds.
+type WorkerPool struct {
+	Client         *http.Client
+	Attempts       int
+	RetryDelay     time.Duration
+	HealthInterval time.Duration
+	// LocalFallback computes crc32 locally when every attempt fails,
+	// otherwise the pipeline gets empty hashes and the failure is kept
+	// for Err
+	LocalFallback bool
+
+	mu      sync.Mutex
+	workers []*remoteWorker
+	next    int
+	stop    chan struct{}
+	err     error
+}
+
+func NewWorkerPool(urls []string) *WorkerPool {
+	workers := make([]*remoteWorker, 0, len(urls))
+	for _, url := range urls {
+		workers = append(workers, &remoteWorker{url: strings.TrimRight(url, "/"), healthy: true})
+	}
+	return &WorkerPool{
+		Client:         &http.Client{Timeout: 5 * time.Second},
+		Attempts:       3,
+		RetryDelay:     100 * time.Millisecond,
+		HealthInterval: time.Second,
+		workers:        workers,
+		stop:           make(chan struct{}),
+	}
+}
+
+// Start runs periodic health checks until Close is called.
+func (p *WorkerPool) Start() {
+	go func() {
+		ticker := time.NewTicker(p.HealthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.CheckHealth()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (p *WorkerPool) Close() {
+	close(p.stop)
+}
+
+func (p *WorkerPool) CheckHealth() {
+	p.mu.Lock()
+	workers := append([]*remoteWorker(nil), p.workers...)
+	p.mu.Unlock()
+
+	for _, w := range workers {
+		healthy := false
+		if resp, err := p.Client.Get(w.url + "/health"); err == nil {
+			healthy = resp.StatusCode == http.StatusOK
+			resp.Body.Close()
+		}
+		p.mu.Lock()
+		w.healthy = healthy
+		p.mu.Unlock()
+	}
+}
+
+func (p *WorkerPool) Healthy() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	urls := make([]string, 0, len(p.workers))
+	for _, w := range p.workers {
+		if w.healthy {
+			urls = append(urls, w.url)
+		}
+	}
+	return urls
+}
+
+// pick returns the next healthy worker, or the next one at all if every
+// worker is marked down
+func (p *WorkerPool) pick() *remoteWorker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.workers) == 0 {
+		return nil
+	}
+	for i := 0; i < len(p.workers); i++ {
+		w := p.workers[(p.next+i)%len(p.workers)]
+		if w.healthy {
+			p.next = (p.next + i + 1) % len(p.workers)
+			return w
+		}
+	}
+	w := p.workers[p.next]
+	p.next = (p.next + 1) % len(p.workers)
+	return w
+}
+
+// Err returns the first failed crc32 call without LocalFallback, the results
+// of a pipeline run are wrong if it is not nil.
+func (p *WorkerPool) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *WorkerPool) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *WorkerPool) markDown(w *remoteWorker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w.healthy = false
+}
+
+func (p *WorkerPool) Crc32(data string) (string, error) {
+	var lastErr error
+	for attempt := 0; attempt < p.Attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(p.RetryDelay)
+		}
+		w := p.pick()
+		if w == nil {
+			return "", fmt.Errorf("no workers")
+		}
+		hash, err := p.call(w, data)
+		if err == nil {
+			return hash, nil
+		}
+		p.markDown(w)
+		lastErr = fmt.Errorf("worker %s: %v", w.url, err)
+	}
+	return "", lastErr
+}
+
+func (p *WorkerPool) call(w *remoteWorker, data string) (string, error) {
+	body, err := json.Marshal(crc32Request{Data: data, Salt: DataSignerSalt})
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.Client.Post(w.url+"/crc32", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	res := crc32Response{}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return "", fmt.Errorf("bad response (status %d): %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d: %s", resp.StatusCode, res.Error)
+	}
+	return res.Hash, nil
+}
+
+var crc32Workers *WorkerPool
+
+// EnableRemoteCrc32 makes the pipeline compute crc32 on the pool workers.
+// If every attempt fails the hash is computed locally with LocalFallback,
+// or is empty and the pool Err is set.
+func EnableRemoteCrc32(pool *WorkerPool) {
+	crc32Workers = pool
+}
+
+func DisableRemoteCrc32() {
+	crc32Workers = nil
+}
+
+func computeCrc32(data string) string {
+	if crc32Workers != nil {
+		hash, err := crc32Workers.Crc32(data)
+		if err == nil {
+			return hash
+		}
+		if !crc32Workers.LocalFallback {
+			crc32Workers.fail(fmt.Errorf("remote crc32: %v", err))
+			return ""
+		}
+		logHash("remote crc32 failed, computing locally: %v", err)
+	}
+	return DataSignerCrc32(data)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingWorker struct {
+	calls  uint32
+	broken uint32
+	server *httptest.Server
+}
+
+func newCountingWorker() *countingWorker {
+	cw := &countingWorker{}
+	handler := NewWorkerHandler()
+	cw.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadUint32(&cw.broken) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "broken"}`))
+			return
+		}
+		if r.URL.Path == "/crc32" {
+			atomic.AddUint32(&cw.calls, 1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	return cw
+}
+
+func TestRemoteSigner(t *testing.T) {
+	workers := []*countingWorker{newCountingWorker(), newCountingWorker(), newCountingWorker()}
+	for _, w := range workers {
+		defer w.server.Close()
+	}
+	atomic.StoreUint32(&workers[2].broken, 1)
+
+	pool := NewWorkerPool([]string{workers[0].server.URL, workers[1].server.URL, workers[2].server.URL})
+	pool.RetryDelay = 0
+	EnableRemoteCrc32(pool)
+	defer DisableRemoteCrc32()
+
+	testResult := "NOT_SET"
+	inputData := []int{0, 1}
+	start := time.Now()
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, fibNum := range inputData {
+				out <- fibNum
+			}
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			testResult = (<-in).(string)
+		}),
+	)
+	end := time.Since(start)
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	if testResult != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", testResult, expected)
+	}
+	if end > 3*time.Second {
+		t.Errorf("execition too long\nGot: %s\nExpected: <%s", end, 3*time.Second)
+	}
+
+	calls0, calls1 := atomic.LoadUint32(&workers[0].calls), atomic.LoadUint32(&workers[1].calls)
+	if calls0+calls1 != uint32(len(inputData)*8) || calls0 == 0 || calls1 == 0 {
+		t.Errorf("calls not spread over healthy workers: %d, %d", calls0, calls1)
+	}
+	if healthy := pool.Healthy(); len(healthy) != 2 {
+		t.Errorf("broken worker not marked down: %v", healthy)
+	}
+
+	atomic.StoreUint32(&workers[2].broken, 0)
+	pool.CheckHealth()
+	if healthy := pool.Healthy(); len(healthy) != 3 {
+		t.Errorf("recovered worker not marked up: %v", healthy)
+	}
+}
+
+func TestRemoteSignerAllDown(t *testing.T) {
+	worker := newCountingWorker()
+	worker.server.Close()
+
+	pool := NewWorkerPool([]string{worker.server.URL})
+	pool.RetryDelay = 0
+	if _, err := pool.Crc32("0"); err == nil {
+		t.Errorf("expected error when no worker is reachable")
+	}
+
+	EnableRemoteCrc32(pool)
+	defer DisableRemoteCrc32()
+	if hash := signCrc32("0"); hash != "" || pool.Err() == nil {
+		t.Errorf("expected a failed pool, got %q", hash)
+	}
+	pool.LocalFallback = true
+	if hash := signCrc32("0"); hash != "4108050209" {
+		t.Errorf("expected local fallback, got %q", hash)
+	}
+}
+
+// TestHelperWorker is run by startWorker in a separate process as a crc32
+// worker
+func TestHelperWorker(t *testing.T) {
+	addr := os.Getenv("SIGNER_TEST_WORKER_ADDR")
+	if addr == "" {
+		t.Skip("run by startWorker only")
+	}
+	err := runSigner([]string{"-salt", os.Getenv("SIGNER_TEST_WORKER_SALT"), "-worker", addr}, nil, ioutil.Discard, os.Stderr)
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// startWorker runs a worker process with salt and returns its url and the
+// function killing it
+func startWorker(t *testing.T, salt string) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperWorker$")
+	cmd.Env = append(os.Environ(), "SIGNER_TEST_WORKER_ADDR="+addr, "SIGNER_TEST_WORKER_SALT="+salt)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	kill := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	t.Cleanup(kill)
+
+	url := "http://" + addr
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if resp, err := http.Get(url + "/health"); err == nil {
+			resp.Body.Close()
+			return url, kill
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("worker %s did not start", url)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRemoteSignerProcesses(t *testing.T) {
+	// the last worker has another salt and must not be used
+	url0, kill0 := startWorker(t, "")
+	url1, kill1 := startWorker(t, "")
+	url2, _ := startWorker(t, "other")
+	workers := strings.Join([]string{url0, url1, url2}, ",")
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542\n"
+	stdout := new(bytes.Buffer)
+	start := time.Now()
+	if err := runSigner([]string{"-workers", workers}, strings.NewReader("0\n1\n"), stdout, ioutil.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stdout.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", stdout.String(), expected)
+	}
+	if end := time.Since(start); end > 4*time.Second {
+		t.Errorf("execition too long\nGot: %s\nExpected: <%s", end, 4*time.Second)
+	}
+
+	kill0()
+	kill1()
+	stdout.Reset()
+	if err := runSigner([]string{"-workers", url0 + "," + url1}, strings.NewReader("0\n"), stdout, ioutil.Discard); err == nil || stdout.Len() != 0 {
+		t.Errorf("expected error without workers, got %v %q", err, stdout.String())
+	}
+	if err := runSigner([]string{"-workers", url2, "-local-fallback"}, strings.NewReader("0\n1\n"), stdout, ioutil.Discard); err != nil || stdout.String() != expected {
+		t.Errorf("expected local fallback, got %v %q", err, stdout.String())
+	}
+}
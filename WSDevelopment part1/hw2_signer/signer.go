@@ -12,7 +12,7 @@ func parallelCrc32(val string) chan string {
 	crcChan := make(chan string, 1)
 	go func() {
 		defer close(crcChan)
-		c := DataSignerCrc32(val)
+		c := signCrc32(val)
 		crcChan <- c
 	}()
 	return crcChan
@@ -21,16 +21,18 @@ func parallelCrc32(val string) chan string {
 type md5Value struct {
 	data string
 	md5  string
+	// endSpan ends the trace span of the value
+	endSpan func()
 }
 
-func parallelCrcMd5(valToMd5 chan string, mdChanOut chan md5Value) {
+func parallelCrcMd5(valToMd5 chan md5Value, mdChanOut chan md5Value) {
 	defer close(mdChanOut)
 	for {
 		if v, ok := <-valToMd5; !ok {
 			break
 		} else {
-			c := md5Value{data: v, md5: DataSignerMd5(v)}
-			mdChanOut <- c
+			v.md5 = signMd5(v.data)
+			mdChanOut <- v
 		}
 	}
 }
@@ -39,44 +41,66 @@ func concatenateSingle(cm md5Value, out chan interface{}, wg *sync.WaitGroup) {
 	defer wg.Done()
 	crc := parallelCrc32(cm.data)
 	crcmd := parallelCrc32(cm.md5)
-	res := <-crc + "~" + <-crcmd
-	out <- res
+	crcData, crcMd5 := <-crc, <-crcmd
+	res := crcData + "~" + crcMd5
+	cm.endSpan()
+	logHash("%[1]s SingleHash data %[1]s\n"+
+		"%[1]s SingleHash md5(data) %[2]s\n"+
+		"%[1]s SingleHash crc32(md5(data)) %[3]s\n"+
+		"%[1]s SingleHash crc32(data) %[4]s\n"+
+		"%[1]s SingleHash result %[5]s",
+		cm.data, cm.md5, crcMd5, crcData, res)
+	traceSend(out, res)
 }
 
 func SingleHash(in, out chan interface{}) {
 
-	md5ChanIn := make(chan string, 10)
+	md5ChanIn := make(chan md5Value, 10)
 
 	singleWg := &sync.WaitGroup{}
 	toCrc := make(chan md5Value, 10)
 
 	go parallelCrcMd5(md5ChanIn, toCrc)
 
+	// md5 results are taken while values still arrive, both channels fill
+	// up otherwise
+	crcDone := make(chan struct{})
+	go func() {
+		defer close(crcDone)
+		for elem := range toCrc {
+			singleWg.Add(1)
+			go concatenateSingle(elem, out, singleWg)
+		}
+	}()
+
 	for {
-		signerValRaw, ok := <-in
+		signerValRaw, ok := traceRecv(in)
 		if !ok {
-			close(md5ChanIn)
 			break
-		} else {
-			signerInt, ok := signerValRaw.(int)
-			if !ok {
-				return
-			}
-			signerVal := strconv.Itoa(signerInt)
-			md5ChanIn <- signerVal
 		}
+		var signerVal string
+		switch v := signerValRaw.(type) {
+		case int:
+			signerVal = strconv.Itoa(v)
+		case string:
+			signerVal = v
+		default:
+			// the values in flight still have to be awaited
+			continue
+		}
+		md5ChanIn <- md5Value{data: signerVal, endSpan: traceItem(out, signerVal)}
 	}
+	close(md5ChanIn)
 
-	for elem := range toCrc {
-		singleWg.Add(1)
-		go concatenateSingle(elem, out, singleWg)
-	}
+	<-crcDone
 	singleWg.Wait()
 }
 
 func calculateMultiHash(val string, out chan interface{}, wgAll *sync.WaitGroup) {
+	endSpan := traceItem(out, val)
 
 	var multiHashResult string
+	var multiHashLog string
 	vals := make([]chan string, 6)
 
 	for i := 0; i <= 5; i++ {
@@ -84,10 +108,14 @@ func calculateMultiHash(val string, out chan interface{}, wgAll *sync.WaitGroup)
 	}
 
 	for i := 0; i <= 5; i++ {
-		multiHashResult += <-vals[i]
+		crc := <-vals[i]
+		multiHashResult += crc
+		multiHashLog += fmt.Sprintf("%s MultiHash: crc32(th+step1)) %d %s\n", val, i, crc)
 	}
 
-	out <- multiHashResult
+	endSpan()
+	logHash("%s%s MultiHash result: %s", multiHashLog, val, multiHashResult)
+	traceSend(out, multiHashResult)
 	wgAll.Done()
 }
 
@@ -95,7 +123,11 @@ func MultiHash(in, out chan interface{}) {
 
 	wg := &sync.WaitGroup{}
 
-	for valRow := range in {
+	for {
+		valRow, ok := traceRecv(in)
+		if !ok {
+			break
+		}
 		val, ok := valRow.(string)
 		if !ok {
 			return
@@ -111,7 +143,7 @@ func CombineResults(in, out chan interface{}) {
 	dataArr := make([]string, 0)
 
 	for {
-		if val, ok := <-in; !ok {
+		if val, ok := traceRecv(in); !ok {
 			break
 		} else {
 			if valString, ok := val.(string); ok {
@@ -122,9 +154,12 @@ func CombineResults(in, out chan interface{}) {
 		}
 	}
 
+	endSpan := traceItem(out, len(dataArr))
 	sort.Strings(dataArr)
 	combination := strings.Join(dataArr, "_")
-	out <- combination
+	endSpan()
+	logHash("CombineResults %s", combination)
+	traceSend(out, combination)
 }
 
 
@@ -133,43 +168,23 @@ func ExecutePipeline(pipelineJobs ...job) {
 	out := make(chan interface{})
 
 	mainWg := &sync.WaitGroup{}
+	// the trace methods do nothing if it is nil
+	trace := currentTrace()
+	first := trace.begin(pipelineJobs)
 
-	for _, worker := range pipelineJobs {
+	for i, worker := range pipelineJobs {
 		nextStep := make(chan interface{})
 		mainWg.Add(1)
-		go func(in, out chan interface{}, worker func(in, out chan interface{}), wg *sync.WaitGroup) {
+		go func(i int, in, out chan interface{}, worker func(in, out chan interface{}), wg *sync.WaitGroup) {
+			trace.stageStarted(i, in, out)
 			worker(in, out)
+			trace.stageFinished(i)
 			wg.Done()
 			close(out)
-		}(in, out, worker, mainWg)
+		}(first+i, in, out, worker, mainWg)
 
 		in, out = out, nextStep
 	}
 	mainWg.Wait()
-}
-
-func main() {
-	inputData := []int{0, 1, 1, 2, 3, 5, 8, 13, 21, 34}
-
-	hashSignJobs := []job{
-		job(func(in, out chan interface{}) {
-			for _, fibNum := range inputData {
-				out <- fibNum
-			}
-		}),
-		job(SingleHash),
-		job(MultiHash),
-		job(CombineResults),
-		job(func(in, out chan interface{}) {
-			dataRaw := <-in
-			data, ok := dataRaw.(string)
-			if !ok {
-				return
-			}
-			fmt.Printf("Final result %v\n", data)
-		}),
-	}
-
-	ExecutePipeline(hashSignJobs...)
-
+	trace.end(first, first+len(pipelineJobs)-1)
 }
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	stageSingle  = "single"
+	stageMulti   = "multi"
+	stageCombine = "combine"
+)
+
+// SignatureSpec describes a signature recipe as a list of pipeline stages.
+//
+// A "single" stage hashes every incoming value with each chain of Hashes and
+// joins the results with Separator. A "multi" stage does the same for
+// strconv.Itoa(th)+value, th = 0..Iterations-1, and joins all results in
+// order. A "combine" stage collects all values, sorts them and joins them
+// with Separator.
+type SignatureSpec struct {
+	Stages []StageSpec `json:"stages"`
+}
+
+type StageSpec struct {
+	Stage      string     `json:"stage"`
+	Hashes     [][]string `json:"hashes,omitempty"`
+	Iterations int        `json:"iterations,omitempty"`
+	Separator  string     `json:"separator"`
+}
+
+// DefaultSignatureSpec is the recipe implemented by SingleHash, MultiHash
+// and CombineResults.
+var DefaultSignatureSpec = SignatureSpec{
+	Stages: []StageSpec{
+		{Stage: stageSingle, Hashes: [][]string{{"crc32"}, {"md5", "crc32"}}, Separator: "~"},
+		{Stage: stageMulti, Hashes: [][]string{{"crc32"}}, Iterations: 6},
+		{Stage: stageCombine, Separator: "_"},
+	},
+}
+
+// DataSignerMd5 overheats when called concurrently
+var md5Mu = &sync.Mutex{}
+
+var hashAlgorithms = map[string]func(string) string{
+	"crc32": signCrc32,
+	"md5": func(data string) string {
+		md5Mu.Lock()
+		defer md5Mu.Unlock()
+		return signMd5(data)
+	},
+	"sha256": func(data string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(data+DataSignerSalt)))
+	},
+	"xxhash": func(data string) string {
+		return strconv.FormatUint(xxhash64([]byte(data+DataSignerSalt)), 10)
+	},
+}
+
+// LoadSignatureSpec reads a spec in JSON, or in YAML with the same keys.
+func LoadSignatureSpec(r io.Reader) (*SignatureSpec, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("bad signature spec: %v", err)
+		}
+	}
+
+	spec := &SignatureSpec{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(spec); err != nil {
+		return nil, fmt.Errorf("bad signature spec: %v", err)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func (s *SignatureSpec) Validate() error {
+	if len(s.Stages) == 0 {
+		return fmt.Errorf("signature spec has no stages")
+	}
+	for i, st := range s.Stages {
+		switch st.Stage {
+		case stageSingle, stageMulti:
+			if len(st.Hashes) == 0 {
+				return fmt.Errorf("stage %d (%s): no hashes", i, st.Stage)
+			}
+			for _, chain := range st.Hashes {
+				if len(chain) == 0 {
+					return fmt.Errorf("stage %d (%s): empty hash chain", i, st.Stage)
+				}
+				for _, alg := range chain {
+					if _, ok := hashAlgorithms[alg]; !ok {
+						return fmt.Errorf("stage %d (%s): unknown algorithm %q", i, st.Stage, alg)
+					}
+				}
+			}
+			if st.Stage == stageMulti && st.Iterations <= 0 {
+				return fmt.Errorf("stage %d (%s): iterations must be positive", i, st.Stage)
+			}
+		case stageCombine:
+		default:
+			return fmt.Errorf("stage %d: unknown stage %q", i, st.Stage)
+		}
+	}
+	return nil
+}
+
+// Jobs compiles the spec into jobs for ExecutePipeline.
+func (s *SignatureSpec) Jobs() ([]job, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	jobs := make([]job, 0, len(s.Stages))
+	for _, st := range s.Stages {
+		switch st.Stage {
+		case stageSingle:
+			jobs = append(jobs, hashStage(st.Hashes, 1, false, st.Separator))
+		case stageMulti:
+			jobs = append(jobs, hashStage(st.Hashes, st.Iterations, true, st.Separator))
+		case stageCombine:
+			jobs = append(jobs, combineStage(st.Separator))
+		}
+	}
+	return jobs, nil
+}
+
+func hashChain(chain []string, val string) string {
+	for _, alg := range chain {
+		val = hashAlgorithms[alg](val)
+	}
+	return val
+}
+
+func hashStage(chains [][]string, iterations int, prefixed bool, sep string) job {
+	return func(in, out chan interface{}) {
+		wg := &sync.WaitGroup{}
+		for {
+			valRaw, ok := traceRecv(in)
+			if !ok {
+				break
+			}
+			var val string
+			switch v := valRaw.(type) {
+			case string:
+				val = v
+			case int:
+				val = strconv.Itoa(v)
+			default:
+				// the hashes in flight still have to be awaited
+				continue
+			}
+
+			wg.Add(1)
+			go func(val string) {
+				defer wg.Done()
+				endSpan := traceItem(out, val)
+				parts := make([]chan string, 0, iterations*len(chains))
+				for th := 0; th < iterations; th++ {
+					data := val
+					if prefixed {
+						data = strconv.Itoa(th) + val
+					}
+					for _, chain := range chains {
+						res := make(chan string, 1)
+						go func(chain []string, data string) {
+							res <- hashChain(chain, data)
+						}(chain, data)
+						parts = append(parts, res)
+					}
+				}
+				results := make([]string, len(parts))
+				for i, res := range parts {
+					results[i] = <-res
+				}
+				res := strings.Join(results, sep)
+				endSpan()
+				traceSend(out, res)
+			}(val)
+		}
+		wg.Wait()
+	}
+}
+
+func combineStage(sep string) job {
+	return func(in, out chan interface{}) {
+		dataArr := make([]string, 0)
+		for {
+			val, ok := traceRecv(in)
+			if !ok {
+				break
+			}
+			valString, ok := val.(string)
+			if !ok {
+				continue
+			}
+			dataArr = append(dataArr, valString)
+		}
+		endSpan := traceItem(out, len(dataArr))
+		sort.Strings(dataArr)
+		res := strings.Join(dataArr, sep)
+		endSpan()
+		traceSend(out, res)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func runSpec(t *testing.T, spec *SignatureSpec, inputData ...interface{}) string {
+	jobs, err := spec.Jobs()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result := "NOT_SET"
+	pipeline := []job{
+		job(func(in, out chan interface{}) {
+			for _, val := range inputData {
+				out <- val
+			}
+		}),
+	}
+	pipeline = append(pipeline, jobs...)
+	pipeline = append(pipeline, job(func(in, out chan interface{}) {
+		result = (<-in).(string)
+	}))
+	ExecutePipeline(pipeline...)
+	return result
+}
+
+func TestSignatureSpecDefault(t *testing.T) {
+	specJSON := `{"stages": [
+		{"stage": "single", "hashes": [["crc32"], ["md5", "crc32"]], "separator": "~"},
+		{"stage": "multi", "hashes": [["crc32"]], "iterations": 6, "separator": ""},
+		{"stage": "combine", "separator": "_"}
+	]}`
+	spec, err := LoadSignatureSpec(strings.NewReader(specJSON))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	if res := runSpec(t, spec, 0, 1); res != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", res, expected)
+	}
+}
+
+func TestSignatureSpecYAML(t *testing.T) {
+	specYAML := `---
+# the recipe of SingleHash, MultiHash and CombineResults
+stages:
+  - stage: single
+    hashes:
+      - [crc32]
+      - [md5, crc32]  # md5 first
+    separator: "~"
+  - stage: multi
+    hashes: [[crc32]]
+    iterations: 6
+  -
+    stage: combine
+    separator: _
+`
+	spec, err := LoadSignatureSpec(strings.NewReader(specYAML))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(*spec, DefaultSignatureSpec) {
+		t.Errorf("specs not match\nGot: %+v\nExpected: %+v", *spec, DefaultSignatureSpec)
+	}
+}
+
+func TestYAMLToJSON(t *testing.T) {
+	cases := map[string]string{
+		"a: 1\nb: [x, 'it''s', \"q#\\\"\"]\n":     `{"a":1,"b":["x","it's","q#\""]}`,
+		"list:\n- 1\n- {k: v, n: null}\nempty:\n": `{"empty":null,"list":[1,{"k":"v","n":null}]}`,
+		"- - a\n  - b\n- c:\n    d: true\n":       `[["a","b"],{"c":{"d":true}}]`,
+		"key: a:b # comment\n":                    `{"key":"a:b"}`,
+	}
+	for in, expected := range cases {
+		res, err := yamlToJSON([]byte(in))
+		if err != nil || string(res) != expected {
+			t.Errorf("yamlToJSON(%q) = %s, %v, expected %s", in, res, err, expected)
+		}
+	}
+
+	for _, in := range []string{"", "a: 1\na: 2\n", "a:\n  b: 1\n c: 2\n", "a: [1, 2\n", "a: 'x\n", "\ta: 1\n", "a: 1\n  b: 2\n"} {
+		if res, err := yamlToJSON([]byte(in)); err == nil {
+			t.Errorf("expected error for %q, got %s", in, res)
+		}
+	}
+}
+
+func TestSignatureSpecCustom(t *testing.T) {
+	spec := &SignatureSpec{Stages: []StageSpec{
+		{Stage: stageSingle, Hashes: [][]string{{"sha256"}, {"xxhash"}}, Separator: "|"},
+		{Stage: stageCombine, Separator: ","},
+	}}
+
+	expected := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad|" +
+		strconv.FormatUint(0x44bc2cf5ad770999, 10)
+	if res := runSpec(t, spec, "abc"); res != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", res, expected)
+	}
+}
+
+func TestSignatureSpecSkipsUnknownValues(t *testing.T) {
+	spec := &SignatureSpec{Stages: []StageSpec{
+		{Stage: stageSingle, Hashes: [][]string{{"md5"}}},
+		{Stage: stageCombine, Separator: ","},
+	}}
+
+	// md5 is slow, so "abc" is still hashed when 1.5 arrives
+	expected := "900150983cd24fb0d6963f7d28e17f72"
+	if res := runSpec(t, spec, "abc", 1.5, []byte("abc")); res != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", res, expected)
+	}
+}
+
+func TestSignatureSpecInvalid(t *testing.T) {
+	cases := []string{
+		`{"stages": []}`,
+		`{"stages": [{"stage": "single", "hashes": [["sha1"]]}]}`,
+		`{"stages": [{"stage": "multi", "hashes": [["crc32"]]}]}`,
+		`{"stages": [{"stage": "single", "hashes": [[]]}]}`,
+		`{"stages": [{"stage": "shuffle"}]}`,
+		`{"stages": [{"stage": "combine", "sep": "_"}]}`,
+		`{"stages": `,
+		"stages:\n  - stage: combine\n    separator: 1\n",
+		"stages: [{stage: single, hashes: [[sha1]]}]\n",
+	}
+	for _, c := range cases {
+		if _, err := LoadSignatureSpec(strings.NewReader(c)); err == nil {
+			t.Errorf("expected error for spec %s", c)
+		}
+	}
+}
+
+func TestXXHash64(t *testing.T) {
+	cases := map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	}
+	for in, expected := range cases {
+		if res := xxhash64([]byte(in)); res != expected {
+			t.Errorf("xxhash64(%q) = %x, expected %x", in, res, expected)
+		}
+	}
+}
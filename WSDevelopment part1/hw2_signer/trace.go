@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// PipelineTrace collects per-stage metrics and spans of pipeline runs.
+//
+// ExecutePipeline records when every stage starts and finishes. Stages send
+// and take values with traceSend and traceRecv to record items in and out
+// and the time blocked on the channels. Channels between stages are
+// unbuffered, so the items a stage sends are the items the next one takes
+// and only one side has to record them. Stages that know their items
+// (SingleHash, MultiHash, CombineResults and spec stages) also record a
+// span of the work on every item with traceItem, from taking the item to
+// having its result ready.
+//
+// Concurrent runs with the same trace add their stages one after another.
+type PipelineTrace struct {
+	mu     sync.Mutex
+	start  time.Time
+	Stages []*StageMetrics
+	spans  []traceSpan
+	// inputs and outputs map the channels around a stage to its index
+	inputs  map[chan interface{}]int
+	outputs map[chan interface{}]int
+	nextID  int
+}
+
+type StageMetrics struct {
+	Name     string
+	ItemsIn  int
+	ItemsOut int
+	Start    time.Time
+	End      time.Time
+	// InputWait and OutputWait sum the time blocked taking and sending
+	// values, Busy sums the item spans. They exceed Wall when the stage
+	// handles items concurrently.
+	InputWait  time.Duration
+	OutputWait time.Duration
+	Busy       time.Duration
+	// items counts the item spans
+	items int
+}
+
+type traceSpan struct {
+	name  string
+	cat   string
+	stage int
+	// id links the begin and end of concurrent item spans, 0 for stages
+	id    int
+	start time.Time
+	dur   time.Duration
+	args  map[string]string
+}
+
+const traceValueLen = 64
+
+// pipelineTrace records ExecutePipeline runs if set
+var (
+	pipelineTraceMu sync.RWMutex
+	pipelineTrace   *PipelineTrace
+)
+
+func NewPipelineTrace() *PipelineTrace {
+	return &PipelineTrace{
+		inputs:  make(map[chan interface{}]int),
+		outputs: make(map[chan interface{}]int),
+	}
+}
+
+// EnableTracing makes ExecutePipeline record its runs into trace. Runs
+// already started are not affected.
+func EnableTracing(trace *PipelineTrace) {
+	pipelineTraceMu.Lock()
+	defer pipelineTraceMu.Unlock()
+	pipelineTrace = trace
+}
+
+func DisableTracing() {
+	EnableTracing(nil)
+}
+
+func currentTrace() *PipelineTrace {
+	pipelineTraceMu.RLock()
+	defer pipelineTraceMu.RUnlock()
+	return pipelineTrace
+}
+
+func (m *StageMetrics) Wall() time.Duration {
+	return m.End.Sub(m.Start)
+}
+
+// begin adds the stages of a run of the jobs and returns the index of the
+// first one
+func (t *PipelineTrace) begin(pipelineJobs []job) int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.Stages) == 0 {
+		t.start = time.Now()
+	}
+	first := len(t.Stages)
+	for _, worker := range pipelineJobs {
+		t.Stages = append(t.Stages, &StageMetrics{Name: fmt.Sprintf("%d:%s", len(t.Stages), jobName(worker))})
+	}
+	return first
+}
+
+func (t *PipelineTrace) stageStarted(i int, in, out chan interface{}) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Stages[i].Start = time.Now()
+	t.inputs[in] = i
+	t.outputs[out] = i
+}
+
+func (t *PipelineTrace) stageFinished(i int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.Stages[i]
+	st.End = time.Now()
+	t.spans = append(t.spans, traceSpan{name: st.Name, cat: "stage", stage: i, start: st.Start, dur: st.End.Sub(st.Start)})
+}
+
+// end fills the items of the stages from first to last recorded only by
+// their neighbours
+func (t *PipelineTrace) end(first, last int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := first; i < last; i++ {
+		sender, receiver := t.Stages[i], t.Stages[i+1]
+		if sender.ItemsOut < receiver.ItemsIn {
+			sender.ItemsOut = receiver.ItemsIn
+		}
+		receiver.ItemsIn = sender.ItemsOut
+	}
+}
+
+// traceSend sends val to out, recording the item and the time blocked if
+// out is the output of a traced stage
+func traceSend(out chan interface{}, val interface{}) {
+	t := currentTrace()
+	if t == nil {
+		out <- val
+		return
+	}
+	start := time.Now()
+	out <- val
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if stage, ok := t.outputs[out]; ok {
+		st := t.Stages[stage]
+		st.ItemsOut++
+		st.OutputWait += time.Since(start)
+	}
+}
+
+// traceRecv takes a value from in like a receive with ok, recording the
+// item and the time blocked if in is the input of a traced stage
+func traceRecv(in chan interface{}) (interface{}, bool) {
+	t := currentTrace()
+	if t == nil {
+		val, ok := <-in
+		return val, ok
+	}
+	start := time.Now()
+	val, ok := <-in
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if stage, found := t.inputs[in]; found {
+		st := t.Stages[stage]
+		if ok {
+			st.ItemsIn++
+		}
+		st.InputWait += time.Since(start)
+	}
+	return val, ok
+}
+
+func noopSpanEnd() {}
+
+// traceItem starts the span of the work on val in the stage writing to out
+// and returns the function ending it. It does nothing unless tracing is
+// enabled.
+func traceItem(out chan interface{}, val interface{}) func() {
+	t := currentTrace()
+	if t == nil {
+		return noopSpanEnd
+	}
+	start := time.Now()
+	return func() {
+		t.addItem(out, start, time.Now(), val)
+	}
+}
+
+func (t *PipelineTrace) addItem(out chan interface{}, start, end time.Time, val interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stage, ok := t.outputs[out]
+	if !ok {
+		// not a stage of the traced run
+		return
+	}
+	st := t.Stages[stage]
+	st.items++
+	st.Busy += end.Sub(start)
+	value := fmt.Sprint(val)
+	if len(value) > traceValueLen {
+		value = value[:traceValueLen] + "..."
+	}
+	t.nextID++
+	t.spans = append(t.spans, traceSpan{
+		name:  fmt.Sprintf("item #%d", st.items),
+		cat:   "item",
+		stage: stage,
+		id:    t.nextID,
+		start: start,
+		dur:   end.Sub(start),
+		args:  map[string]string{"value": value},
+	})
+}
+
+func jobName(worker job) string {
+	name := runtime.FuncForPC(reflect.ValueOf(worker).Pointer()).Name()
+	return strings.TrimPrefix(name, "main.")
+}
+
+// WriteReport prints per-stage metrics as a table.
+func (t *PipelineTrace) WriteReport(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "stage\tin\tout\twall\tbusy\tin wait\tout wait\tavg item\t")
+	for _, st := range t.Stages {
+		avg := time.Duration(0)
+		if st.items > 0 {
+			avg = st.Busy / time.Duration(st.items)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t\n", st.Name, st.ItemsIn, st.ItemsOut,
+			roundDuration(st.Wall()), roundDuration(st.Busy), roundDuration(st.InputWait),
+			roundDuration(st.OutputWait), roundDuration(avg))
+	}
+	return tw.Flush()
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+type chromeTraceEvent struct {
+	Name  string            `json:"name"`
+	Cat   string            `json:"cat,omitempty"`
+	Phase string            `json:"ph"`
+	Ts    int64             `json:"ts"`
+	Dur   int64             `json:"dur,omitempty"`
+	Pid   int               `json:"pid"`
+	Tid   int               `json:"tid"`
+	ID    int               `json:"id,omitempty"`
+	Args  map[string]string `json:"args,omitempty"`
+}
+
+// WriteChromeTrace writes the run in the Chrome trace event format, which
+// can be opened with chrome://tracing or https://ui.perfetto.dev. Items of
+// a stage overlap, so they are async events.
+func (t *PipelineTrace) WriteChromeTrace(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	events := make([]chromeTraceEvent, 0, len(t.Stages)+2*len(t.spans))
+	for i, st := range t.Stages {
+		events = append(events, chromeTraceEvent{
+			Name:  "thread_name",
+			Phase: "M",
+			Pid:   1,
+			Tid:   i,
+			Args:  map[string]string{"name": st.Name},
+		})
+	}
+	for _, span := range t.spans {
+		ts := span.start.Sub(t.start).Microseconds()
+		if span.id == 0 {
+			st := t.Stages[span.stage]
+			events = append(events, chromeTraceEvent{
+				Name: span.name, Cat: span.cat, Phase: "X", Ts: ts, Dur: span.dur.Microseconds(),
+				Pid: 1, Tid: span.stage,
+				Args: map[string]string{
+					"items in":    strconv.Itoa(st.ItemsIn),
+					"items out":   strconv.Itoa(st.ItemsOut),
+					"busy":        roundDuration(st.Busy).String(),
+					"input wait":  roundDuration(st.InputWait).String(),
+					"output wait": roundDuration(st.OutputWait).String(),
+				},
+			})
+			continue
+		}
+		events = append(events,
+			chromeTraceEvent{
+				Name: span.name, Cat: span.cat, Phase: "b", Ts: ts,
+				Pid: 1, Tid: span.stage, ID: span.id, Args: span.args,
+			},
+			chromeTraceEvent{
+				Name: span.name, Cat: span.cat, Phase: "e", Ts: ts + span.dur.Microseconds(),
+				Pid: 1, Tid: span.stage, ID: span.id,
+			})
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipelineTrace(t *testing.T) {
+	origCrc32 := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		time.Sleep(20 * time.Millisecond)
+		return "c" + data
+	}
+	trace := NewPipelineTrace()
+	EnableTracing(trace)
+	defer func() {
+		DataSignerCrc32 = origCrc32
+		DisableTracing()
+	}()
+
+	result := ""
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for i := 0; i < 3; i++ {
+				out <- i
+			}
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			result = (<-in).(string)
+		}),
+	)
+
+	if strings.Count(result, "_") != 2 {
+		t.Errorf("traced pipeline lost values: %q", result)
+	}
+	if len(trace.Stages) != 5 {
+		t.Fatalf("expected 5 stages, got %d", len(trace.Stages))
+	}
+	// the source and the sink are counted by their neighbours
+	itemsIn, itemsOut := []int{0, 3, 3, 3, 1}, []int{3, 3, 3, 1, 0}
+	for i, st := range trace.Stages {
+		if st.ItemsIn != itemsIn[i] || st.ItemsOut != itemsOut[i] {
+			t.Errorf("stage %s: expected %d/%d items, got %d/%d", st.Name, itemsIn[i], itemsOut[i], st.ItemsIn, st.ItemsOut)
+		}
+	}
+	if !strings.HasSuffix(trace.Stages[1].Name, "SingleHash") {
+		t.Errorf("unexpected stage name %s", trace.Stages[1].Name)
+	}
+
+	// every item waits for at least one crc32
+	for _, st := range trace.Stages[1:3] {
+		if st.Busy < 3*20*time.Millisecond {
+			t.Errorf("stage %s: busy too small: %s", st.Name, st.Busy)
+		}
+	}
+	// MultiHash has nothing to do before the first SingleHash result
+	if st := trace.Stages[2]; st.InputWait < 20*time.Millisecond {
+		t.Errorf("stage %s: input wait too small: %s", st.Name, st.InputWait)
+	}
+
+	report := new(bytes.Buffer)
+	if err := trace.WriteReport(report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "avg item") || strings.Count(report.String(), "\n") != 6 {
+		t.Errorf("unexpected report:\n%s", report.String())
+	}
+
+	chrome := new(bytes.Buffer)
+	if err := trace.WriteChromeTrace(chrome); err != nil {
+		t.Fatal(err)
+	}
+	parsed := struct {
+		TraceEvents []chromeTraceEvent `json:"traceEvents"`
+	}{}
+	if err := json.Unmarshal(chrome.Bytes(), &parsed); err != nil {
+		t.Fatalf("bad chrome trace: %v", err)
+	}
+	phases := map[string]int{}
+	for _, ev := range parsed.TraceEvents {
+		phases[ev.Phase]++
+		if ev.Phase == "X" && ev.Tid == 3 && (ev.Args["items in"] != "3" || ev.Args["items out"] != "1" || ev.Args["input wait"] == "") {
+			t.Errorf("unexpected stage event %+v", ev)
+		}
+	}
+	if phases["M"] != 5 || phases["X"] != 5 || phases["b"] != 7 || phases["e"] != 7 {
+		t.Errorf("unexpected chrome trace events: %v", phases)
+	}
+}
+
+// TestPipelineTraceUnbuffered checks that tracing keeps the channels
+// between stages unbuffered
+func TestPipelineTraceUnbuffered(t *testing.T) {
+	trace := NewPipelineTrace()
+	EnableTracing(trace)
+	defer DisableTracing()
+
+	var sent time.Duration
+	start := time.Now()
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			traceSend(out, 1)
+			traceSend(out, 2)
+			sent = time.Since(start)
+		}),
+		job(func(in, out chan interface{}) {
+			<-in
+			time.Sleep(50 * time.Millisecond)
+			<-in
+		}),
+	)
+	if sent < 50*time.Millisecond {
+		t.Errorf("second value sent before it was taken, after %s", sent)
+	}
+	if st := trace.Stages[0]; st.ItemsOut != 2 || st.OutputWait < 50*time.Millisecond {
+		t.Errorf("stage %s: unexpected output %d items, %s wait", st.Name, st.ItemsOut, st.OutputWait)
+	}
+	if st := trace.Stages[1]; st.ItemsIn != 2 {
+		t.Errorf("stage %s: expected 2 items in, got %d", st.Name, st.ItemsIn)
+	}
+}
+
+func TestPipelineTraceConcurrent(t *testing.T) {
+	trace := NewPipelineTrace()
+	EnableTracing(trace)
+	defer DisableTracing()
+
+	forward := func(in, out chan interface{}) {
+		for {
+			val, ok := traceRecv(in)
+			if !ok {
+				return
+			}
+			traceSend(out, val)
+		}
+	}
+	wg := &sync.WaitGroup{}
+	for run := 0; run < 2; run++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ExecutePipeline(
+				job(func(in, out chan interface{}) {
+					for i := 0; i < 5; i++ {
+						traceSend(out, i)
+					}
+				}),
+				job(forward),
+				job(func(in, out chan interface{}) {
+					for range in {
+					}
+				}),
+			)
+		}()
+		go func() {
+			defer wg.Done()
+			// enabling the same trace again does not disturb the runs
+			EnableTracing(trace)
+		}()
+	}
+	wg.Wait()
+
+	if len(trace.Stages) != 6 {
+		t.Fatalf("expected 6 stages, got %d", len(trace.Stages))
+	}
+	for i, st := range trace.Stages {
+		if i%3 == 1 && (st.ItemsIn != 5 || st.ItemsOut != 5) {
+			t.Errorf("stage %s: expected 5/5 items, got %d/%d", st.Name, st.ItemsIn, st.ItemsOut)
+		}
+	}
+}
+
+func TestTraceItemDisabled(t *testing.T) {
+	out := make(chan interface{})
+	traceItem(out, 1)()
+
+	trace := NewPipelineTrace()
+	EnableTracing(trace)
+	defer DisableTracing()
+	ExecutePipeline(job(func(in, out chan interface{}) {}))
+	// out is not a stage of the traced run
+	traceItem(out, 1)()
+	if trace.Stages[0].items != 0 || len(trace.spans) != 1 {
+		t.Errorf("unexpected trace %+v", trace.Stages[0])
+	}
+}
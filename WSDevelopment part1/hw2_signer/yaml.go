@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A YAML subset for signature specs, the homework has no dependencies:
+// block mappings and sequences, flow sequences and mappings, quoted and
+// plain scalars and comments. Anchors, tags, multi-line scalars and
+// multiple documents are not supported.
+
+// yamlToJSON converts a YAML document to JSON, so specs in both formats go
+// through the same decoder
+func yamlToJSON(data []byte) ([]byte, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(string(data), "\n") {
+		text := strings.TrimRight(stripYAMLComment(raw), " \r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || i == 0 && trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs are not allowed in indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return nil, fmt.Errorf("yaml: empty document")
+	}
+
+	val, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return json.Marshal(val)
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	line := p.lines[len(p.lines)-1].num
+	if p.pos < len(p.lines) {
+		line = p.lines[p.pos].num
+	}
+	return fmt.Errorf("yaml: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block parses the mapping or sequence starting at the current line
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if isYAMLItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(p.lines[p.pos].text); ok {
+		return p.mapping(indent)
+	}
+	line := p.lines[p.pos]
+	p.pos++
+	return parseYAMLFlow(line.text, line.num)
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	res := make(map[string]interface{})
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && !isYAMLItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		key, value, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, p.errorf("expected key: value")
+		}
+		if _, dup := res[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.pos++
+
+		if value != "" {
+			val, err := parseYAMLFlow(value, line.num)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = val
+			continue
+		}
+		switch {
+		case p.pos == len(p.lines):
+			res[key] = nil
+		case p.lines[p.pos].indent > indent:
+			val, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = val
+		case p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text):
+			// sequences may stay at the key indentation
+			val, err := p.sequence(indent)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = val
+		default:
+			res[key] = nil
+		}
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return res, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	res := make([]interface{}, 0)
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" {
+			p.pos++
+			if p.pos == len(p.lines) || p.lines[p.pos].indent <= indent {
+				res = append(res, nil)
+				continue
+			}
+			val, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, val)
+			continue
+		}
+
+		// "- key: value" starts a mapping indented past the dash
+		p.lines[p.pos] = yamlLine{num: line.num, indent: indent + len(line.text) - len(rest), text: rest}
+		val, err := p.block(p.lines[p.pos].indent)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, val)
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return res, nil
+}
+
+// splitYAMLKey splits "key: value" and "key:", quoted keys are not supported
+func splitYAMLKey(text string) (key, value string, ok bool) {
+	if text == "" || strings.ContainsRune(`"'[{`, rune(text[0])) {
+		return "", "", false
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// stripYAMLComment removes a "#" comment outside of quotes
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// parseYAMLFlow parses a scalar or a flow collection taking the whole text
+func parseYAMLFlow(text string, num int) (interface{}, error) {
+	f := &yamlFlow{s: text}
+	val, err := f.value()
+	if err == nil {
+		if f.skipSpaces(); f.pos < len(f.s) {
+			err = fmt.Errorf("unexpected %q", f.s[f.pos:])
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("yaml: line %d: %v", num, err)
+	}
+	return val, nil
+}
+
+type yamlFlow struct {
+	s   string
+	pos int
+	// depth is the number of open collections, plain scalars end at ","
+	// and brackets only inside them
+	depth int
+}
+
+func (f *yamlFlow) skipSpaces() {
+	for f.pos < len(f.s) && f.s[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) value() (interface{}, error) {
+	f.skipSpaces()
+	if f.pos == len(f.s) {
+		return nil, fmt.Errorf("expected value")
+	}
+	switch f.s[f.pos] {
+	case '[':
+		return f.collection(']')
+	case '{':
+		return f.collection('}')
+	case '"':
+		end := f.pos + 1
+		for ; end < len(f.s) && f.s[end] != '"'; end++ {
+			if f.s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(f.s) {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		val, err := strconv.Unquote(f.s[f.pos : end+1])
+		f.pos = end + 1
+		return val, err
+	case '\'':
+		var b strings.Builder
+		for end := f.pos + 1; end < len(f.s); end++ {
+			if f.s[end] != '\'' {
+				b.WriteByte(f.s[end])
+				continue
+			}
+			if end+1 < len(f.s) && f.s[end+1] == '\'' {
+				b.WriteByte('\'')
+				end++
+				continue
+			}
+			f.pos = end + 1
+			return b.String(), nil
+		}
+		return nil, fmt.Errorf("unterminated string")
+	}
+
+	start := f.pos
+	for f.pos < len(f.s) && (f.depth == 0 || !strings.ContainsRune(",]}", rune(f.s[f.pos]))) {
+		if f.depth > 0 && f.s[f.pos] == ':' && (f.pos+1 == len(f.s) || f.s[f.pos+1] == ' ') {
+			break
+		}
+		f.pos++
+	}
+	return yamlScalar(strings.TrimSpace(f.s[start:f.pos])), nil
+}
+
+func (f *yamlFlow) collection(end byte) (interface{}, error) {
+	f.pos++
+	f.depth++
+	defer func() { f.depth-- }()
+	list := make([]interface{}, 0)
+	dict := make(map[string]interface{})
+	for first := true; ; first = false {
+		f.skipSpaces()
+		if f.pos < len(f.s) && f.s[f.pos] == end && first {
+			f.pos++
+			break
+		}
+		val, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		if end == '}' {
+			key, ok := val.(string)
+			if f.skipSpaces(); !ok || f.pos == len(f.s) || f.s[f.pos] != ':' {
+				return nil, fmt.Errorf("expected key: value")
+			}
+			f.pos++
+			if val, err = f.value(); err != nil {
+				return nil, err
+			}
+			dict[key] = val
+		} else {
+			list = append(list, val)
+		}
+
+		if f.skipSpaces(); f.pos == len(f.s) {
+			return nil, fmt.Errorf("expected %q", end)
+		}
+		if c := f.s[f.pos]; c == end {
+			f.pos++
+			break
+		} else if c != ',' {
+			return nil, fmt.Errorf("expected \",\" or %q, got %q", end, c)
+		}
+		f.pos++
+	}
+	if end == '}' {
+		return dict, nil
+	}
+	return list, nil
+}
+
+// yamlScalar resolves a plain scalar to null, a bool, an integer or a
+// string
+func yamlScalar(s string) interface{} {
+	switch s {
+	case "", "~", "null":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return json.Number(s)
+	}
+	return s
+}
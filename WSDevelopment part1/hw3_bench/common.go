@@ -11,7 +11,8 @@ import (
 	// "log"
 )
 
-const filePath string = "./data/users.txt"
+// var so that benchmarks can run on generated datasets
+var filePath string = "./data/users.txt"
 
 func SlowSearch(out io.Writer) {
 	file, err := os.Open(filePath)
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// DatasetConfig describes a synthetic users file. Android and MSIE are the
+// shares of browsers of these families, the rest are desktop browsers
+// without either marker.
+type DatasetConfig struct {
+	Users           int
+	BrowsersPerUser int
+	Android         float64
+	MSIE            float64
+	// distinct user agents per family
+	Variants int
+	Seed     int64
+}
+
+// DefaultDatasetConfig roughly follows data/users.txt
+var DefaultDatasetConfig = DatasetConfig{
+	Users:           1000,
+	BrowsersPerUser: 4,
+	Android:         0.12,
+	MSIE:            0.07,
+	Variants:        60,
+	Seed:            1,
+}
+
+// ParseDatasetConfig overrides fields of DefaultDatasetConfig from a
+// comma separated list like "users=10000,android=0.3".
+func ParseDatasetConfig(s string) (DatasetConfig, error) {
+	c := DefaultDatasetConfig
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return c, fmt.Errorf("dataset: expected key=value, got %q", pair)
+		}
+		key, value := pair[:i], pair[i+1:]
+		var err error
+		switch key {
+		case "users":
+			c.Users, err = strconv.Atoi(value)
+		case "browsers":
+			c.BrowsersPerUser, err = strconv.Atoi(value)
+		case "android":
+			c.Android, err = strconv.ParseFloat(value, 64)
+		case "msie":
+			c.MSIE, err = strconv.ParseFloat(value, 64)
+		case "variants":
+			c.Variants, err = strconv.Atoi(value)
+		case "seed":
+			c.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return c, fmt.Errorf("dataset: unknown key %q", key)
+		}
+		if err != nil {
+			return c, fmt.Errorf("dataset: %s: %v", key, err)
+		}
+	}
+	return c, c.Validate()
+}
+
+func (c DatasetConfig) Validate() error {
+	switch {
+	case c.Users < 1:
+		// SlowSearch can not read an empty file
+		return fmt.Errorf("at least one user is required")
+	case c.BrowsersPerUser < 0:
+		return fmt.Errorf("negative number of browsers per user")
+	case c.Variants < 1:
+		return fmt.Errorf("at least one browser variant is required")
+	case c.Android < 0 || c.MSIE < 0 || c.Android+c.MSIE > 1:
+		return fmt.Errorf("browser shares must be non-negative and sum to at most 1")
+	}
+	return nil
+}
+
+func (c DatasetConfig) String() string {
+	return fmt.Sprintf("users=%d,browsers=%d,android=%g,msie=%g,variants=%d,seed=%d",
+		c.Users, c.BrowsersPerUser, c.Android, c.MSIE, c.Variants, c.Seed)
+}
+
+var (
+	androidAgent = "Mozilla/5.0 (Linux; U; Android %d.%d; en-us; Build/%d) AppleWebKit/%d.%d (KHTML, like Gecko) Version/4.0 Mobile Safari/%d.%d"
+	msieAgent    = "Mozilla/4.0 (compatible; MSIE %d.0; Windows NT %d.%d; Trident/%d.%d)"
+	otherAgents  = []string{
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/%d.%d (KHTML, like Gecko) Chrome/%d.0.%d.%d Safari/537.36",
+		"Mozilla/5.0 (Windows NT %d.%d; rv:%d.0) Gecko/20100101 Firefox/%d.%d",
+		"Opera/9.80 (Macintosh; Intel Mac OS X 10_%d_%d) Presto/2.%d.%d Version/%d.00",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_%d_%d) AppleWebKit/%d.%d.%d (KHTML, like Gecko) Safari/%d",
+	}
+	firstNames = []string{"Sharon", "Susan", "Jonathan", "Judy", "Larry", "Virginia", "Patrick", "Wayne", "Keith", "Lisa"}
+	lastNames  = []string{"Crawford", "Ellis", "Morris", "Bradley", "Henry", "Freeman", "Black", "Butler", "Sullivan", "Ramos"}
+	domains    = []string{"Muxo.edu", "Topiczoom.info", "Oyonder.gov", "Roombo.biz", "Voomm.edu", "Skalith.edu", "Nlounge.com"}
+)
+
+func agentVariants(rnd *rand.Rand, templates []string, n int) []string {
+	variants := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		template := templates[i%len(templates)]
+		args := make([]interface{}, strings.Count(template, "%d"))
+		for j := range args {
+			args[j] = 1 + rnd.Intn(60)
+		}
+		variants = append(variants, fmt.Sprintf(template, args...))
+	}
+	return variants
+}
+
+type syntheticUser struct {
+	Browsers []string `json:"browsers"`
+	Company  string   `json:"company"`
+	Country  string   `json:"country"`
+	Email    string   `json:"email"`
+	Job      string   `json:"job"`
+	Name     string   `json:"name"`
+	Phone    string   `json:"phone"`
+}
+
+// GenerateUsers writes a users file in the data/users.txt format. Like the
+// original file it has no trailing newline.
+func GenerateUsers(w io.Writer, c DatasetConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	rnd := rand.New(rand.NewSource(c.Seed))
+	android := agentVariants(rnd, []string{androidAgent}, c.Variants)
+	msie := agentVariants(rnd, []string{msieAgent}, c.Variants)
+	other := agentVariants(rnd, otherAgents, c.Variants)
+
+	bw := bufio.NewWriter(w)
+	for i := 0; i < c.Users; i++ {
+		user := syntheticUser{
+			Browsers: make([]string, 0, c.BrowsersPerUser),
+			Company:  "Company " + lastNames[rnd.Intn(len(lastNames))],
+			Country:  "Kenya",
+			Job:      "Programmer Analyst #{N}",
+			Name:     firstNames[rnd.Intn(len(firstNames))] + " " + lastNames[rnd.Intn(len(lastNames))],
+			Phone:    fmt.Sprintf("%03d-%02d-%02d", rnd.Intn(1000), rnd.Intn(100), rnd.Intn(100)),
+		}
+		user.Email = fmt.Sprintf("user%d@%s", i, domains[rnd.Intn(len(domains))])
+		for j := 0; j < c.BrowsersPerUser; j++ {
+			switch p := rnd.Float64(); {
+			case p < c.Android:
+				user.Browsers = append(user.Browsers, android[rnd.Intn(len(android))])
+			case p < c.Android+c.MSIE:
+				user.Browsers = append(user.Browsers, msie[rnd.Intn(len(msie))])
+			default:
+				user.Browsers = append(user.Browsers, other[rnd.Intn(len(other))])
+			}
+		}
+
+		if i > 0 {
+			if err := bw.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		line, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		if _, err := bw.Write(line); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateUsers(t *testing.T) {
+	config, err := ParseDatasetConfig("users=300,android=0.4,msie=0.3,seed=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := new(bytes.Buffer), new(bytes.Buffer)
+	if err := GenerateUsers(first, config); err != nil {
+		t.Fatal(err)
+	}
+	GenerateUsers(second, config)
+	if first.String() != second.String() {
+		t.Errorf("same seed generated different datasets")
+	}
+	if lines := bytes.Count(first.Bytes(), []byte("\n")) + 1; lines != 300 || bytes.HasSuffix(first.Bytes(), []byte("\n")) {
+		t.Errorf("expected 300 lines without trailing newline, got %d", lines)
+	}
+
+	path := filepath.Join(t.TempDir(), "users.txt")
+	if err := run(context.Background(), []string{"generate", "-dataset", config.String(), "-o", path}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	withFilePath(path, func() {
+		slowOut, fastOut := new(bytes.Buffer), new(bytes.Buffer)
+		SlowSearch(slowOut)
+		FastSearch(fastOut)
+		if slowOut.String() != fastOut.String() {
+			t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", fastOut, slowOut)
+		}
+	})
+}
+
+func TestParseDatasetConfig(t *testing.T) {
+	for _, bad := range []string{"users", "users=x", "colour=1", "android=0.7,msie=0.5", "users=0"} {
+		if _, err := ParseDatasetConfig(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+	config, err := ParseDatasetConfig(DefaultDatasetConfig.String())
+	if err != nil || config != DefaultDatasetConfig {
+		t.Errorf("config does not round trip: %v %v", config, err)
+	}
+}
@@ -1,12 +1,10 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"os"
-	"strings"
 
 	easyjson "github.com/mailru/easyjson"
 	jlexer "github.com/mailru/easyjson/jlexer"
@@ -26,6 +24,9 @@ type userProfile struct {
 	Browsers []string `json:"browsers"`
 	Email    string   `json:"email"`
 	Name     string   `json:"name"`
+
+	// unescaped strings of decodeLine
+	scratch []byte
 }
 
 func easyjsonB4ad3f7dDecodeHw3Bench(in *jlexer.Lexer, out *userProfile) {
@@ -142,78 +143,19 @@ func (v *userProfile) UnmarshalEasyJSON(l *jlexer.Lexer) {
 }
 
 func FastSearch(out io.Writer) {
+	FastSearchQuery(out, DefaultQuery)
+}
 
+// FastSearchQuery prints users matching q and the number of unique browsers
+// satisfying its browsers conditions.
+func FastSearchQuery(out io.Writer, q *Query) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		panic(err)
 	}
+	defer file.Close()
 
-	fileScanner := bufio.NewScanner(file)
-
-	seenBrowsers := make([]string, 0, 100)
-	uniqueBrowsers := 0
-
-	var i int
-
-	fmt.Println(out, "found users:")
-
-	user := &userProfile{}
-
-	for {
-
-		ok := fileScanner.Scan()
-		if !ok {
-			break
-		}
-
-		isAndroid := false
-		isMSIE := false
-
-		err := user.UnmarshalJSON(fileScanner.Bytes())
-		if err != nil {
-			panic(err)
-		}
-
-		for _, browser := range user.Browsers {
-
-			notSeenBefore := true
-
-			switch {
-
-			case strings.Contains(browser, "Android"):
-				isAndroid = true
-			case strings.Contains(browser, "MSIE"):
-				isMSIE = true
-			default:
-				continue
-			}
-
-			for _, item := range seenBrowsers {
-				if item == browser {
-					notSeenBefore = false
-					break
-				}
-			}
-
-			if notSeenBefore {
-				// log.Printf("SLOW New browser: %s, first seen: %s", browser, user["name"])
-				seenBrowsers = append(seenBrowsers, browser)
-				uniqueBrowsers++
-			}
-
-		}
-
-		if isAndroid && isMSIE {
-			email := strings.ReplaceAll(user.Email, "@", " [at] ")
-			_, err := fmt.Fprintf(out, "[%d] %s <%s>\n", i, user.Name, email)
-			if err != nil {
-				panic(err)
-			}
-		}
-		i++
-	}
-	_, err = fmt.Fprintln(out, "\nTotal unique browsers", len(seenBrowsers))
-	if err != nil {
+	if _, err := Search(context.Background(), file, out, *q); err != nil {
 		panic(err)
 	}
 }
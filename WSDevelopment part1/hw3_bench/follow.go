@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Follower searches a users file like Searcher and then keeps reading it
+// like tail -f, printing users as they are appended. Users are numbered by
+// their line in the current file, the numbering restarts when the file is
+// truncated or replaced. Empty lines are ignored.
+type Follower struct {
+	Query        *Query
+	Output       Output
+	SkipBadLines bool
+	// Interval between checks for new data, 500ms if zero
+	Interval time.Duration
+	// FromEnd skips users already in the file, they are still counted for
+	// numbering
+	FromEnd bool
+	// Log gets truncation, rotation and unique browsers changes if set
+	Log *log.Logger
+}
+
+const defaultFollowInterval = 500 * time.Millisecond
+
+// FastSearchFollow runs FastSearch on filePath and then follows it until
+// ctx is done.
+func FastSearchFollow(ctx context.Context, out io.Writer) (Stats, error) {
+	return (&Follower{Query: DefaultQuery}).Follow(ctx, filePath, out)
+}
+
+type followState struct {
+	*Follower
+	path    string
+	out     resultWriter
+	file    *os.File
+	info    os.FileInfo
+	offset  int64
+	pending []byte
+	buf     []byte
+	// line number of the next line in the current file
+	line  int
+	skip  bool
+	user  *userProfile
+	seen  map[string]struct{}
+	stats Stats
+}
+
+// Follow returns when ctx is done, after printing the unique browsers of
+// all the lines read. The context error is not reported.
+func (f *Follower) Follow(ctx context.Context, path string, w io.Writer) (Stats, error) {
+	out, err := f.Output.writer(w)
+	if err != nil {
+		return Stats{}, err
+	}
+	s := &followState{
+		Follower: f,
+		path:     path,
+		out:      out,
+		buf:      make([]byte, 64*1024),
+		user:     &userProfile{},
+		seen:     make(map[string]struct{}),
+	}
+	if err := s.open(); err != nil {
+		return s.stats, err
+	}
+	defer func() { s.file.Close() }()
+
+	if f.FromEnd {
+		s.skip = true
+		if err := s.drain(); err != nil {
+			return s.stats, err
+		}
+		s.skip = false
+	}
+	if err := out.begin(); err != nil {
+		return s.stats, err
+	}
+	interval := f.Interval
+	if interval <= 0 {
+		interval = defaultFollowInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.drain(); err != nil {
+			return s.stats, err
+		}
+		if err := s.checkFile(); err != nil {
+			return s.stats, err
+		}
+
+		select {
+		case <-ctx.Done():
+			s.stats.UniqueBrowsers = len(s.seen)
+			return s.stats, out.end(s.stats.UniqueBrowsers)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *followState) open() error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.info = file, info
+	s.restart()
+	return nil
+}
+
+// restart starts reading the current file from the beginning
+func (s *followState) restart() {
+	s.offset, s.line, s.pending = 0, 0, s.pending[:0]
+}
+
+func (s *followState) logf(format string, args ...interface{}) {
+	if s.Log != nil {
+		s.Log.Printf(format, args...)
+	}
+}
+
+// checkFile detects a truncated or replaced file
+func (s *followState) checkFile() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		// the file is being rotated, read the old one meanwhile
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !os.SameFile(info, s.info) {
+		// read what was appended before the rotation
+		if err := s.drain(); err != nil {
+			return err
+		}
+		s.file.Close()
+		s.logf("%s: file replaced, reading from the beginning", s.path)
+		return s.open()
+	}
+	if info.Size() < s.offset {
+		s.logf("%s: file truncated, reading from the beginning", s.path)
+		s.restart()
+	}
+	return nil
+}
+
+// drain processes everything appended to the file so far
+func (s *followState) drain() error {
+	for {
+		n, err := s.file.ReadAt(s.buf, s.offset)
+		s.offset += int64(n)
+		data := s.buf[:n]
+		for len(data) > 0 {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				s.pending = append(s.pending, data...)
+				break
+			}
+			line := data[:i]
+			if len(s.pending) > 0 {
+				s.pending = append(s.pending, line...)
+				line = s.pending
+			}
+			if err := s.process(line); err != nil {
+				return err
+			}
+			s.pending = s.pending[:0]
+			data = data[i+1:]
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// users.txt has no trailing newline, so the last line is complete as
+	// soon as it decodes
+	if len(s.pending) == 0 {
+		return nil
+	}
+	s.user.reset()
+	if s.user.decodeLine(s.pending) == nil {
+		if err := s.process(s.pending); err != nil {
+			return err
+		}
+		s.pending = s.pending[:0]
+	}
+	return nil
+}
+
+func (s *followState) process(line []byte) error {
+	if len(line) == 0 {
+		return nil
+	}
+	id := s.line
+	s.line++
+	if s.skip {
+		return nil
+	}
+	s.stats.Lines++
+
+	s.user.reset()
+	if err := s.user.decodeLine(line); err != nil {
+		if s.SkipBadLines {
+			s.stats.SkippedLines++
+			return nil
+		}
+		return &LineError{Line: id + 1, Err: err}
+	}
+
+	before := len(s.seen)
+	for _, browser := range s.user.Browsers {
+		if s.Query.CountsBrowser(browser) {
+			key := s.Output.UniqueBy.key(browser)
+			if _, ok := s.seen[key]; !ok {
+				s.seen[strings.Clone(key)] = struct{}{}
+			}
+		}
+	}
+	if len(s.seen) != before {
+		s.logf("unique browsers: %d", len(s.seen))
+	}
+
+	if s.Query.Match(s.user) {
+		s.stats.Matched++
+		return s.out.user(id, s.user.Name, s.user.Email)
+	}
+	return nil
+}
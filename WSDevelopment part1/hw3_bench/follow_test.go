@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is read by the test while Follow writes it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func followUser(name string, match bool) string {
+	browsers := `["Mozilla/4.0 (compatible; MSIE 8.0)","Firefox/40.0"]`
+	if match {
+		browsers = `["Mozilla/4.0 (compatible; MSIE 8.0)","Android 4.3 ` + name + `"]`
+	}
+	return fmt.Sprintf(`{"browsers":%s,"name":%q,"email":"%s@x.org"}`, browsers, name, strings.ToLower(name))
+}
+
+func appendFile(t *testing.T, path, data string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitOutput(t *testing.T, out *syncBuffer, expected string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if strings.Contains(out.String(), expected) {
+			return
+		}
+	}
+	t.Fatalf("%q not printed, got:\n%s", expected, out.String())
+}
+
+type followResult struct {
+	stats Stats
+	err   error
+}
+
+func startFollow(t *testing.T, f *Follower, path string, out *syncBuffer) (context.CancelFunc, chan followResult) {
+	f.Query, f.Interval = DefaultQuery, 5*time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan followResult, 1)
+	go func() {
+		stats, err := f.Follow(ctx, path, out)
+		done <- followResult{stats, err}
+	}()
+	t.Cleanup(cancel)
+	return cancel, done
+}
+
+func TestFollow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	appendFile(t, path, followUser("A", true)+"\n"+followUser("B", false))
+
+	out := &syncBuffer{}
+	cancel, done := startFollow(t, &Follower{}, path, out)
+	waitOutput(t, out, "found users:\n[0] A <a [at] x.org>\n")
+
+	// appended users.txt style, then with a trailing newline in two writes
+	appendFile(t, path, "\n"+followUser("C", true))
+	waitOutput(t, out, "[2] C <c [at] x.org>\n")
+	line := followUser("D", true) + "\n"
+	appendFile(t, path, "\n"+line[:10])
+	time.Sleep(20 * time.Millisecond)
+	appendFile(t, path, line[10:])
+	waitOutput(t, out, "[3] D <d [at] x.org>\n")
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	// give the follower a chance to see the truncation
+	time.Sleep(20 * time.Millisecond)
+	appendFile(t, path, followUser("E", true))
+	waitOutput(t, out, "[0] E <e [at] x.org>\n")
+
+	old, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	old.WriteString("\n" + followUser("F", true))
+	old.Close()
+	appendFile(t, path, followUser("G", true))
+	waitOutput(t, out, "[1] F <f [at] x.org>\n")
+	waitOutput(t, out, "[0] G <g [at] x.org>\n")
+
+	cancel()
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	// every matching user brings its own Android browser
+	if !strings.HasSuffix(out.String(), "\nTotal unique browsers 7\n") || res.stats.Matched != 6 || res.stats.UniqueBrowsers != 7 {
+		t.Errorf("unexpected result %+v\n%s", res.stats, out.String())
+	}
+	if strings.Contains(out.String(), "B <") {
+		t.Errorf("not matching user printed:\n%s", out.String())
+	}
+}
+
+func TestFollowFromEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	appendFile(t, path, followUser("A", true)+"\n{\n"+followUser("B", true))
+
+	out := &syncBuffer{}
+	cancel, done := startFollow(t, &Follower{FromEnd: true, SkipBadLines: true}, path, out)
+	// the header is printed after skipping
+	waitOutput(t, out, "found users:\n")
+	appendFile(t, path, "\n{\n"+followUser("C", true))
+	waitOutput(t, out, "found users:\n[4] C <c [at] x.org>\n")
+	cancel()
+	res := <-done
+	if res.err != nil || res.stats.Lines != 2 || res.stats.SkippedLines != 1 || strings.Contains(out.String(), "A <") {
+		t.Errorf("unexpected result %+v %v\n%s", res.stats, res.err, out.String())
+	}
+
+	out = &syncBuffer{}
+	_, done = startFollow(t, &Follower{FromEnd: true}, path, out)
+	waitOutput(t, out, "found users:\n")
+	appendFile(t, path, "\n{\n")
+	select {
+	case res := <-done:
+		if lineErr, ok := res.err.(*LineError); !ok || lineErr.Line != 6 {
+			t.Errorf("expected error for line 6, got %v", res.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("malformed line not reported")
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const indexPath string = "./data/users.idx"
+
+// bytes before Offset checked to detect a rewritten source file
+const indexTailLen = 64
+
+// Index is an inverted index of a users file: every distinct browser maps
+// to the sorted IDs (line numbers) of the users having it. Queries evaluate
+// browsers conditions against the browser dictionary and intersect or merge
+// the posting lists.
+type Index struct {
+	Source string
+	// Offset is how many bytes of Source are indexed, Unterminated is set
+	// if the last indexed line had no trailing newline
+	Offset       int64
+	TailSum      uint32
+	Unterminated bool
+
+	Users    []IndexedUser
+	Browsers []string
+	Postings [][]int
+
+	browserIDs map[string]int
+}
+
+type IndexedUser struct {
+	Name  string
+	Email string
+}
+
+func NewIndex(source string) *Index {
+	return &Index{Source: source, browserIDs: make(map[string]int)}
+}
+
+func LoadIndex(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	idx := &Index{}
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(idx); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	idx.browserIDs = make(map[string]int, len(idx.Browsers))
+	for id, browser := range idx.Browsers {
+		idx.browserIDs[browser] = id
+	}
+	return idx, nil
+}
+
+// OpenIndex loads the index at path if it was built from source, otherwise
+// starts a new one. Either way it is brought up to date with source, changed
+// reports whether it has to be saved.
+func OpenIndex(ctx context.Context, path, source string) (idx *Index, changed bool, err error) {
+	idx, err = LoadIndex(path)
+	switch {
+	case os.IsNotExist(err):
+		idx, changed = NewIndex(source), true
+	case err != nil:
+		return nil, false, err
+	case idx.Source != source:
+		idx, changed = NewIndex(source), true
+	}
+	offset := idx.Offset
+	if _, err := idx.Update(ctx); err != nil {
+		return nil, false, err
+	}
+	return idx, changed || idx.Offset != offset, nil
+}
+
+// Save writes the index atomically.
+func (idx *Index) Save(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := gob.NewEncoder(w).Encode(idx); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (idx *Index) reset() {
+	*idx = *NewIndex(idx.Source)
+}
+
+// Update indexes lines appended to Source since the last update. If the
+// indexed part of the file has changed the index is rebuilt. It returns the
+// number of users added. Malformed lines are errors: skipping them would
+// shift the IDs of the users after them.
+func (idx *Index) Update(ctx context.Context) (int, error) {
+	file, err := os.Open(idx.Source)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if ok, err := idx.sameSource(file, info.Size()); err != nil {
+		return 0, err
+	} else if !ok {
+		idx.reset()
+	}
+
+	if _, err := file.Seek(idx.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(file)
+
+	if idx.Unterminated {
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if c != '\n' {
+			// the last line was still being written, start over
+			idx.reset()
+			return idx.Update(ctx)
+		}
+		idx.Offset++
+		idx.Unterminated = false
+	}
+
+	added := 0
+	user := &userProfile{}
+	for {
+		if added%searchCtxCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return added, err
+			}
+		}
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return added, err
+		}
+		if len(line) == 0 {
+			break
+		}
+		content := bytes.TrimRight(line, "\r\n")
+
+		*user = userProfile{Browsers: user.Browsers[:0]}
+		if parseErr := user.UnmarshalJSON(content); parseErr != nil {
+			if err == io.EOF {
+				// incomplete last line, index it on the next update
+				break
+			}
+			return added, &LineError{Line: len(idx.Users) + 1, Err: parseErr}
+		}
+		idx.add(user)
+		idx.Offset += int64(len(line))
+		idx.Unterminated = err == io.EOF
+		added++
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	idx.TailSum, err = tailSum(file, idx.Offset)
+	return added, err
+}
+
+func (idx *Index) sameSource(file *os.File, size int64) (bool, error) {
+	if idx.Offset == 0 {
+		return true, nil
+	}
+	if size < idx.Offset {
+		return false, nil
+	}
+	sum, err := tailSum(file, idx.Offset)
+	return sum == idx.TailSum, err
+}
+
+func tailSum(file *os.File, offset int64) (uint32, error) {
+	start := offset - indexTailLen
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, offset-start)
+	if _, err := file.ReadAt(buf, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(buf), nil
+}
+
+func (idx *Index) add(user *userProfile) {
+	id := len(idx.Users)
+	idx.Users = append(idx.Users, IndexedUser{Name: user.Name, Email: user.Email})
+	for _, browser := range user.Browsers {
+		bid, ok := idx.browserIDs[browser]
+		if !ok {
+			bid = len(idx.Browsers)
+			idx.browserIDs[browser] = bid
+			idx.Browsers = append(idx.Browsers, browser)
+			idx.Postings = append(idx.Postings, nil)
+		}
+		postings := idx.Postings[bid]
+		if len(postings) == 0 || postings[len(postings)-1] != id {
+			idx.Postings[bid] = append(postings, id)
+		}
+	}
+}
+
+// Search prints users matching q in the FastSearch format.
+func (idx *Index) Search(w io.Writer, q *Query) (Stats, error) {
+	return idx.SearchOutput(context.Background(), w, q, Output{})
+}
+
+// SearchOutput is Search with the given output format and redaction,
+// stopping when ctx is done.
+func (idx *Index) SearchOutput(ctx context.Context, w io.Writer, q *Query, o Output) (Stats, error) {
+	stats := Stats{Lines: len(idx.Users)}
+	out, err := o.writer(w)
+	if err != nil {
+		return stats, err
+	}
+	if err := out.begin(); err != nil {
+		return stats, err
+	}
+	for i, id := range idx.eval(q.root) {
+		if i%searchCtxCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return stats, err
+			}
+		}
+		user := idx.Users[id]
+		if err := out.user(id, user.Name, user.Email); err != nil {
+			return stats, err
+		}
+		stats.Matched++
+	}
+	seen := make(map[string]struct{})
+	for _, browser := range idx.Browsers {
+		if q.CountsBrowser(browser) {
+			seen[o.UniqueBy.key(browser)] = struct{}{}
+		}
+	}
+	stats.UniqueBrowsers = len(seen)
+	return stats, out.end(stats.UniqueBrowsers)
+}
+
+// eval returns the sorted IDs of users matching the node
+func (idx *Index) eval(n *queryNode) []int {
+	switch n.kind {
+	case nodeAnd:
+		return intersectPostings(idx.eval(n.left), idx.eval(n.right))
+	case nodeOr:
+		return unionPostings(idx.eval(n.left), idx.eval(n.right))
+	case nodeNot:
+		return idx.complement(idx.eval(n.left))
+	}
+
+	marks := make([]bool, len(idx.Users))
+	switch {
+	case isBrowserField(n.field):
+		for bid, browser := range idx.Browsers {
+			if n.test(browser) {
+				for _, id := range idx.Postings[bid] {
+					marks[id] = true
+				}
+			}
+		}
+	case n.field == fieldEmail:
+		for id, user := range idx.Users {
+			marks[id] = n.test(user.Email)
+		}
+	case n.field == fieldName:
+		for id, user := range idx.Users {
+			marks[id] = n.test(user.Name)
+		}
+	}
+
+	ids := make([]int, 0)
+	for id, marked := range marks {
+		if marked {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (idx *Index) complement(ids []int) []int {
+	res := make([]int, 0, len(idx.Users)-len(ids))
+	j := 0
+	for id := range idx.Users {
+		if j < len(ids) && ids[j] == id {
+			j++
+			continue
+		}
+		res = append(res, id)
+	}
+	return res
+}
+
+func intersectPostings(a, b []int) []int {
+	res := make([]int, 0)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+func unionPostings(a, b []int) []int {
+	res := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			res = append(res, a[i])
+			i++
+		case a[i] > b[j]:
+			res = append(res, b[j])
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	res = append(res, a[i:]...)
+	return append(res, b[j:]...)
+}
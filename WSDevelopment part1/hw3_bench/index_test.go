@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var indexTestQueries = []string{
+	defaultQuery,
+	`browsers contains "Chrome" OR email endswith ".org"`,
+	`NOT browsers contains "Android" AND name startswith "J"`,
+	`browsers equals "Opera/9.80 (Windows NT 5.1; U; en) Presto/2.9.168 Version/11.51"`,
+}
+
+func checkIndexSearch(t *testing.T, idx *Index, source string) {
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range indexTestQueries {
+		q := MustParseQuery(query)
+		expected := new(bytes.Buffer)
+		expectedStats, err := Search(context.Background(), bytes.NewReader(data), expected, *q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := new(bytes.Buffer)
+		stats, err := idx.Search(got, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != expected.String() || stats != expectedStats {
+			t.Errorf("%s: results not match\nGot:\n%v%+v\nExpected:\n%v%+v", query, got, stats, expected, expectedStats)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "users.txt")
+	idxPath := filepath.Join(dir, "users.idx")
+
+	// the first half ends with a newline, the rest of the file does not
+	half := strings.Join(lines[:len(lines)/2], "")
+	if err := ioutil.WriteFile(source, []byte(half), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, changed, err := OpenIndex(context.Background(), idxPath, source)
+	if err != nil || !changed {
+		t.Fatalf("index not built: %v", err)
+	}
+	if err := idx.Save(idxPath); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexSearch(t, idx, source)
+
+	if err := ioutil.WriteFile(source, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, changed, err = OpenIndex(context.Background(), idxPath, source)
+	if err != nil || !changed {
+		t.Fatalf("index not updated: %v", err)
+	}
+	if len(idx.Users) != len(lines) || !idx.Unterminated {
+		t.Fatalf("expected %d unterminated users, got %d", len(lines), len(idx.Users))
+	}
+	checkIndexSearch(t, idx, source)
+	if err := idx.Save(idxPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, changed, err = OpenIndex(context.Background(), idxPath, source); err != nil || changed {
+		t.Errorf("unchanged index updated: %v", err)
+	}
+
+	// appending to the unterminated last line
+	appended := lines[0]
+	f, err := os.OpenFile(source, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("\n" + strings.TrimSuffix(appended, "\n"))
+	f.Close()
+	if added, err := idx.Update(context.Background()); err != nil || added != 1 {
+		t.Errorf("expected one user added, got %d: %v", added, err)
+	}
+	checkIndexSearch(t, idx, source)
+
+	// truncated file
+	if err := ioutil.WriteFile(source, []byte(lines[0]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Update(context.Background()); err != nil || len(idx.Users) != 1 {
+		t.Errorf("index not rebuilt, %d users: %v", len(idx.Users), err)
+	}
+	checkIndexSearch(t, idx, source)
+}
+
+func TestIndexCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	idxPath := filepath.Join(dir, "users.idx")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := OpenIndex(ctx, idxPath, filePath); err != context.Canceled {
+		t.Errorf("index built with canceled context: %v", err)
+	}
+	idx, _, err := OpenIndex(context.Background(), idxPath, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if _, err := idx.SearchOutput(ctx, out, DefaultQuery, Output{}); err != context.Canceled {
+		t.Errorf("search not canceled: %v", err)
+	}
+
+	args := []string{"search", "-index", idxPath, "-skip-bad", filePath}
+	if err := run(context.Background(), args, out, out); err == nil {
+		t.Error("-skip-bad accepted with -index")
+	}
+}
+
+func TestPostings(t *testing.T) {
+	a, b := []int{1, 3, 5, 7}, []int{2, 3, 7, 9}
+	if res := intersectPostings(a, b); !equalInts(res, []int{3, 7}) {
+		t.Errorf("bad intersection %v", res)
+	}
+	if res := unionPostings(a, b); !equalInts(res, []int{1, 2, 3, 5, 7, 9}) {
+		t.Errorf("bad union %v", res)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
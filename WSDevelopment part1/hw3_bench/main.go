@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string, stdout, stderr io.Writer) error
+}
+
+var commands = map[string]command{
+	"search":   {"search users matching a query", searchCommand},
+	"index":    {"build or update the browsers index", indexCommand},
+	"report":   {"print browser and email domain statistics", reportCommand},
+	"generate": {"generate a synthetic users file", generateCommand},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		stop()
+		os.Exit(2)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return searchCommand(ctx, args, stdout, stderr)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		printCommands(stderr)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return cmd.run(ctx, args[1:], stdout, stderr)
+}
+
+func printCommands(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+func newFlagSet(name, args string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: hw3_bench %s [flags] %s\n\n", name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// inputName returns the only positional argument, filePath by default
+func inputName(flags *flag.FlagSet) (string, error) {
+	switch flags.NArg() {
+	case 0:
+		return filePath, nil
+	case 1:
+		return flags.Arg(0), nil
+	}
+	flags.Usage()
+	return "", fmt.Errorf("expected one input, got %d", flags.NArg())
+}
+
+func searchCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("search", "[file|url|-]", stderr)
+	query := flags.String("query", defaultQuery, "users `query`, fields browsers, email, name, family, version, os and device")
+	skipBad := flags.Bool("skip-bad", false, "skip malformed lines instead of failing")
+	printStats := flags.Bool("stats", false, "print search statistics to stderr")
+	index := flags.String("index", "", "search through the index at `path`, updating it first")
+	format := flags.String("format", "text", "output `format`: text or json")
+	unique := flags.String("unique", string(UniqueBrowser), "count unique browsers by `key`: browser, family or version")
+	parallel := flags.Int("parallel", 1, "scan the file in `n` concurrent shards, 0 for one per CPU")
+	follow := flags.Bool("follow", false, "keep reading the file as it grows until interrupted")
+	fromEnd := flags.Bool("from-end", false, "with -follow, skip the users already in the file")
+	interval := flags.Duration("interval", defaultFollowInterval, "with -follow, how often to check for new users")
+	redact := flags.String("redact", DefaultRedaction.String(), "redaction `policies` per field (name, email): none, at, partial, hash or drop")
+	redactKey := flags.String("redact-key-file", "", "read the key of the hash redaction policy from `file`")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	redaction, err := ParseRedaction(*redact)
+	if err != nil {
+		return err
+	}
+	if *redactKey != "" {
+		key, err := ioutil.ReadFile(*redactKey)
+		if err != nil {
+			return err
+		}
+		redaction.HashKey = strings.TrimSpace(string(key))
+	}
+	if _, err := redaction.redactor(); err != nil {
+		return err
+	}
+	output := Output{Format: *format, Redaction: redaction, UniqueBy: UniqueBy(*unique)}
+	name, err := inputName(flags)
+	if err != nil {
+		return err
+	}
+	q, err := ParseQuery(*query)
+	if err != nil {
+		return err
+	}
+
+	var stats Stats
+	switch {
+	case *parallel != 1:
+		if *follow || *index != "" || name == "-" || strings.Contains(name, "://") {
+			return fmt.Errorf("-parallel needs a file and no -follow or -index")
+		}
+		file, openErr := os.Open(name)
+		if openErr != nil {
+			return openErr
+		}
+		defer file.Close()
+		stats, err = Searcher{SkipBadLines: *skipBad, Output: output}.SearchParallel(ctx, file, stdout, *q, *parallel)
+	case *follow:
+		if *index != "" || name == "-" || strings.Contains(name, "://") {
+			return fmt.Errorf("-follow needs a file and no -index")
+		}
+		follower := &Follower{
+			Query:        q,
+			Output:       output,
+			SkipBadLines: *skipBad,
+			Interval:     *interval,
+			FromEnd:      *fromEnd,
+			Log:          log.New(stderr, "", log.LstdFlags),
+		}
+		stats, err = follower.Follow(ctx, name, stdout)
+	case *index != "":
+		if *skipBad {
+			return fmt.Errorf("-index does not support -skip-bad")
+		}
+		idx, openErr := openAndSaveIndex(ctx, *index, name)
+		if openErr != nil {
+			return openErr
+		}
+		stats, err = idx.SearchOutput(ctx, stdout, q, output)
+	default:
+		in, openErr := OpenInput(ctx, name)
+		if openErr != nil {
+			return openErr
+		}
+		defer in.Close()
+		stats, err = Searcher{SkipBadLines: *skipBad, Output: output}.Search(ctx, in, stdout, *q)
+	}
+	if *printStats {
+		fmt.Fprintf(stderr, "lines: %d, matched: %d, unique browsers: %d, skipped: %d\n",
+			stats.Lines, stats.Matched, stats.UniqueBrowsers, stats.SkippedLines)
+	}
+	return err
+}
+
+func indexCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("index", "[file]", stderr)
+	output := flags.String("o", indexPath, "index `path`")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	name, err := inputName(flags)
+	if err != nil {
+		return err
+	}
+
+	idx, err := openAndSaveIndex(ctx, *output, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: %d users, %d browsers\n", *output, len(idx.Users), len(idx.Browsers))
+	return nil
+}
+
+func openAndSaveIndex(ctx context.Context, path, source string) (*Index, error) {
+	idx, changed, err := OpenIndex(ctx, path, source)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := idx.Save(path); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+func reportCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("report", "[file|url|-]", stderr)
+	format := flags.String("format", "table", "output `format`: table, csv or json")
+	top := flags.Int("top", 10, "show only `n` most frequent browsers and domains, 0 for all")
+	skipBad := flags.Bool("skip-bad", false, "skip malformed lines instead of failing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	name, err := inputName(flags)
+	if err != nil {
+		return err
+	}
+
+	var write func(*Report, io.Writer) error
+	switch *format {
+	case "table":
+		write = (*Report).WriteTable
+	case "csv":
+		write = (*Report).WriteCSV
+	case "json":
+		write = (*Report).WriteJSON
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	in, err := OpenInput(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	report, err := BuildReport(ctx, in, *top, *skipBad)
+	if err != nil {
+		return err
+	}
+	return write(report, stdout)
+}
+
+func generateCommand(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("generate", "", stderr)
+	dataset := flags.String("dataset", DefaultDatasetConfig.String(), "dataset `config`, keys users, browsers, android, msie, variants, seed")
+	output := flags.String("o", "-", "output `file`, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return fmt.Errorf("unexpected arguments %v", flags.Args())
+	}
+	config, err := ParseDatasetConfig(*dataset)
+	if err != nil {
+		return err
+	}
+
+	if *output == "-" {
+		return GenerateUsers(stdout, config)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := GenerateUsers(file, config); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
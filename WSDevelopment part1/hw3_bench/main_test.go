@@ -30,6 +30,20 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestSearchParallel(t *testing.T) {
+	slowOut := new(bytes.Buffer)
+	SlowSearch(slowOut)
+	slowResult := slowOut.String()
+
+	for _, shards := range []int{1, 2, 3, 7, 16, 5000} {
+		parallelOut := new(bytes.Buffer)
+		searchShards(parallelOut, DefaultQuery, shards)
+		if parallelResult := parallelOut.String(); slowResult != parallelResult {
+			t.Errorf("results not match for %d shards\nGot:\n%v\nExpected:\n%v", shards, parallelResult, slowResult)
+		}
+	}
+}
+
 // -----
 // go test -bench . -benchmem
 
@@ -44,3 +58,9 @@ func BenchmarkFast(b *testing.B) {
 		FastSearch(ioutil.Discard)
 	}
 }
+
+func BenchmarkFastParallel(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		FastSearchParallel(ioutil.Discard)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Output configures how found users are printed.
+type Output struct {
+	// Format is "text" (the default) or "json"
+	Format    string
+	Redaction Redaction
+	// UniqueBy is UniqueBrowser if empty
+	UniqueBy UniqueBy
+}
+
+// resultWriter prints found users between begin and end
+type resultWriter interface {
+	begin() error
+	user(id int, name, email string) error
+	end(uniqueBrowsers int) error
+}
+
+func (o Output) writer(w io.Writer) (resultWriter, error) {
+	if err := o.UniqueBy.Validate(); err != nil {
+		return nil, err
+	}
+	r, err := o.Redaction.redactor()
+	if err != nil {
+		return nil, err
+	}
+	switch o.Format {
+	case "", "text":
+		return &textWriter{w: w, r: r}, nil
+	case "json":
+		return &jsonWriter{w: w, r: r}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q", o.Format)
+}
+
+// textWriter prints the FastSearch format, dropped fields are left out
+type textWriter struct {
+	w io.Writer
+	r redactor
+}
+
+func (t *textWriter) begin() error {
+	_, err := fmt.Fprintln(t.w, "found users:")
+	return err
+}
+
+func (t *textWriter) user(id int, name, email string) error {
+	switch {
+	case t.r.name != nil && t.r.email != nil:
+		_, err := fmt.Fprintf(t.w, "[%d] %s <%s>\n", id, t.r.name(name), t.r.email(email))
+		return err
+	case t.r.name != nil:
+		_, err := fmt.Fprintf(t.w, "[%d] %s\n", id, t.r.name(name))
+		return err
+	case t.r.email != nil:
+		_, err := fmt.Fprintf(t.w, "[%d] <%s>\n", id, t.r.email(email))
+		return err
+	}
+	_, err := fmt.Fprintf(t.w, "[%d]\n", id)
+	return err
+}
+
+func (t *textWriter) end(uniqueBrowsers int) error {
+	_, err := fmt.Fprintln(t.w, "\nTotal unique browsers", uniqueBrowsers)
+	return err
+}
+
+// jsonWriter prints {"users":[...],"unique_browsers":n} user by user
+type jsonWriter struct {
+	w     io.Writer
+	r     redactor
+	found int
+}
+
+type jsonUser struct {
+	ID    int     `json:"id"`
+	Name  *string `json:"name,omitempty"`
+	Email *string `json:"email,omitempty"`
+}
+
+func (j *jsonWriter) begin() error {
+	_, err := io.WriteString(j.w, `{"users":[`)
+	return err
+}
+
+func (j *jsonWriter) user(id int, name, email string) error {
+	user := jsonUser{ID: id}
+	if j.r.name != nil {
+		name = j.r.name(name)
+		user.Name = &name
+	}
+	if j.r.email != nil {
+		email = j.r.email(email)
+		user.Email = &email
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.found == 0 {
+		sep = "\n"
+	}
+	j.found++
+	if _, err := io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) end(uniqueBrowsers int) error {
+	end := "]"
+	if j.found > 0 {
+		end = "\n]"
+	}
+	_, err := fmt.Fprintf(j.w, "%s,\"unique_browsers\":%d}\n", end, uniqueBrowsers)
+	return err
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+type foundUser struct {
+	index int
+	name  string
+	email string
+}
+
+type shardResult struct {
+	stats Stats
+	found []foundUser
+	// browsers holds the unique browser keys
+	browsers map[string]struct{}
+	err      error
+}
+
+func FastSearchParallel(out io.Writer) {
+	FastSearchQueryParallel(out, DefaultQuery)
+}
+
+// FastSearchQueryParallel works like FastSearchQuery but splits the file into
+// a shard per CPU and scans the shards concurrently.
+func FastSearchQueryParallel(out io.Writer, q *Query) {
+	searchShards(out, q, runtime.NumCPU())
+}
+
+func searchShards(out io.Writer, q *Query, shards int) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	if _, err := (Searcher{}).SearchParallel(context.Background(), file, out, *q, shards); err != nil {
+		panic(err)
+	}
+}
+
+// SearchParallel is Search over a file split into shards scanned
+// concurrently, one per CPU if shards < 1. The output and the stats are the
+// ones of Search, errors included.
+func (s Searcher) SearchParallel(ctx context.Context, file *os.File, w io.Writer, q Query, shards int) (Stats, error) {
+	stats := Stats{}
+	out, err := s.Output.writer(w)
+	if err != nil {
+		return stats, err
+	}
+	magic := make([]byte, len(gzipMagic))
+	if n, _ := file.ReadAt(magic, 0); n == len(magic) && bytes.Equal(magic, gzipMagic) {
+		return stats, fmt.Errorf("%s: compressed input cannot be split into shards", file.Name())
+	}
+
+	if shards < 1 {
+		shards = runtime.NumCPU()
+	}
+	bounds, err := shardBounds(file, shards)
+	if err != nil {
+		return stats, err
+	}
+
+	results := make([]shardResult, len(bounds)-1)
+	wg := &sync.WaitGroup{}
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			section := io.NewSectionReader(file, bounds[i], bounds[i+1]-bounds[i])
+			results[i] = s.scanShard(ctx, section, &q)
+		}(i)
+	}
+	wg.Wait()
+
+	if err := out.begin(); err != nil {
+		return stats, err
+	}
+	seenBrowsers := make(map[string]struct{}, 128)
+	for _, res := range results {
+		for _, user := range res.found {
+			if err := out.user(stats.Lines+user.index, user.name, user.email); err != nil {
+				return stats, err
+			}
+		}
+		for browser := range res.browsers {
+			seenBrowsers[browser] = struct{}{}
+		}
+		if lineErr, ok := res.err.(*LineError); ok {
+			res.err = &LineError{Line: stats.Lines + lineErr.Line, Err: lineErr.Err}
+		}
+		stats.Lines += res.stats.Lines
+		stats.Matched += res.stats.Matched
+		stats.SkippedLines += res.stats.SkippedLines
+		if res.err != nil {
+			return stats, res.err
+		}
+	}
+
+	stats.UniqueBrowsers = len(seenBrowsers)
+	return stats, out.end(stats.UniqueBrowsers)
+}
+
+// shardBounds splits the file into n byte ranges which start at the
+// beginning of a line. It returns n+1 offsets, ranges may be empty.
+func shardBounds(file *os.File, n int) ([]int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if n < 1 {
+		n = 1
+	}
+
+	bounds := make([]int64, n+1)
+	bounds[n] = size
+	buf := make([]byte, 4096)
+	for k := 1; k < n; k++ {
+		pos := size * int64(k) / int64(n)
+		if pos < bounds[k-1] {
+			pos = bounds[k-1]
+		}
+		bounds[k], err = nextLineStart(file, pos, size, buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bounds, nil
+}
+
+// nextLineStart returns pos if a line starts there, otherwise the offset
+// after the next newline
+func nextLineStart(file *os.File, pos, size int64, buf []byte) (int64, error) {
+	if pos == 0 || pos >= size {
+		return pos, nil
+	}
+	for off := pos - 1; off < size; off += int64(len(buf)) {
+		n, err := file.ReadAt(buf, off)
+		for j := 0; j < n; j++ {
+			if buf[j] == '\n' {
+				return off + int64(j) + 1, nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// scanShard searches a shard like Search, numbering lines from 0. Decoded
+// strings point into the scanner buffer, so they are cloned before they
+// leave the shard.
+func (s Searcher) scanShard(ctx context.Context, r io.Reader, q *Query) shardResult {
+	res := shardResult{browsers: make(map[string]struct{}, 100)}
+	scanner := bufio.NewScanner(r)
+	user := &userProfile{}
+
+	for ; scanner.Scan(); res.stats.Lines++ {
+		if res.stats.Lines%searchCtxCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				res.err = err
+				return res
+			}
+		}
+
+		user.reset()
+		if err := user.decodeLine(scanner.Bytes()); err != nil {
+			if s.SkipBadLines {
+				res.stats.SkippedLines++
+				continue
+			}
+			res.err = &LineError{Line: res.stats.Lines + 1, Err: err}
+			return res
+		}
+
+		for _, browser := range user.Browsers {
+			if !q.CountsBrowser(browser) {
+				continue
+			}
+			key := s.UniqueBy.key(browser)
+			if _, ok := res.browsers[key]; !ok {
+				res.browsers[strings.Clone(key)] = struct{}{}
+			}
+		}
+
+		if q.Match(user) {
+			res.stats.Matched++
+			res.found = append(res.found, foundUser{
+				index: res.stats.Lines,
+				name:  strings.Clone(user.Name),
+				email: strings.Clone(user.Email),
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		res.err = &LineError{Line: res.stats.Lines + 1, Err: err}
+	}
+	return res
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSearchParallelMatchesSearch(t *testing.T) {
+	badFile := filepath.Join(t.TempDir(), "bad.txt")
+	if err := ioutil.WriteFile(badFile, []byte(searchTestData+searchTestData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputs := []Output{
+		{},
+		{Format: "json"},
+		{UniqueBy: UniqueFamily},
+		{Format: "json", UniqueBy: UniqueVersion, Redaction: Redaction{Name: RedactPartial, Email: RedactDrop}},
+		{Redaction: Redaction{Name: RedactHash, Email: RedactHash, HashKey: "k"}},
+	}
+
+	for _, name := range []string{filePath, badFile} {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, o := range outputs {
+			for _, skipBad := range []bool{false, true} {
+				s := Searcher{SkipBadLines: skipBad, Output: o}
+				expected := new(bytes.Buffer)
+				expectedStats, expectedErr := s.Search(context.Background(), bytes.NewReader(data), expected, *DefaultQuery)
+
+				for _, shards := range []int{1, 2, 3, 7, 5000} {
+					got := new(bytes.Buffer)
+					stats, err := s.SearchParallel(context.Background(), file, got, *DefaultQuery, shards)
+					if got.String() != expected.String() || stats != expectedStats || !reflect.DeepEqual(err, expectedErr) {
+						t.Errorf("%s %+v skip %v, %d shards: results not match\nGot:\n%s%+v %v\nExpected:\n%s%+v %v",
+							name, o, skipBad, shards, got, stats, err, expected, expectedStats, expectedErr)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSearchParallelErrors(t *testing.T) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (Searcher{}).SearchParallel(ctx, file, ioutil.Discard, *DefaultQuery, 4); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, err := (Searcher{Output: Output{Format: "xml"}}).SearchParallel(context.Background(), file, ioutil.Discard, *DefaultQuery, 4); err == nil {
+		t.Error("expected error for unknown format")
+	}
+
+	gzPath := filepath.Join(t.TempDir(), "users.txt.gz")
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	gz.Write([]byte(searchTestData))
+	gz.Close()
+	if err := ioutil.WriteFile(gzPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gzFile, err := os.Open(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gzFile.Close()
+	if _, err := (Searcher{}).SearchParallel(context.Background(), gzFile, ioutil.Discard, *DefaultQuery, 4); err == nil {
+		t.Error("expected error for compressed input")
+	}
+}
+
+func TestSearchCommandParallel(t *testing.T) {
+	for _, args := range [][]string{
+		{"-format", "json", "-unique", "family"},
+		{"-query", `browsers contains "Chrome" OR email endswith ".org"`, "-stats"},
+	} {
+		expected, expectedErr := new(bytes.Buffer), new(bytes.Buffer)
+		if err := run(context.Background(), append(append([]string{"search"}, args...), filePath), expected, expectedErr); err != nil {
+			t.Fatal(err)
+		}
+		got, gotErr := new(bytes.Buffer), new(bytes.Buffer)
+		if err := run(context.Background(), append(append([]string{"search", "-parallel", "0"}, args...), filePath), got, gotErr); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != expected.String() || gotErr.String() != expectedErr.String() {
+			t.Errorf("%v: results not match\nGot:\n%s%s\nExpected:\n%s%s", args, got, gotErr, expected, expectedErr)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"search", "-parallel", "4", "-"},
+		{"search", "-parallel", "4", "-follow", filePath},
+		{"search", "-parallel", "4", "-index", filepath.Join(t.TempDir(), "users.idx"), filePath},
+	} {
+		if err := run(context.Background(), args, ioutil.Discard, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
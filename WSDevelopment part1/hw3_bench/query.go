@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query is a compiled filter over userProfile. The query language is
+//
+//	expr    := and { "OR" and }
+//	and     := unary { "AND" unary }
+//	unary   := "NOT" unary | "(" expr ")" | field op string
+//	field   := "browsers" | "email" | "name" | "family" | "version" | "os" | "device"
+//	op      := "contains" | "equals" | "startswith" | "endswith"
+//
+// Keywords are case-insensitive and strings are Go-quoted. family, version,
+// os and device are the UserAgent fields of the user browsers. A browsers
+// condition, or a condition on a UserAgent field, holds if it holds for any
+// of the user browsers. Browsers that satisfy any such condition not under
+// NOT are counted as unique browsers.
+type Query struct {
+	source   string
+	root     *queryNode
+	match    func(user *userProfile) bool
+	browsers []func(browser string) bool
+}
+
+type nodeKind int
+
+const (
+	nodeAnd nodeKind = iota
+	nodeOr
+	nodeNot
+	nodeCond
+)
+
+const (
+	fieldBrowsers = "browsers"
+	fieldEmail    = "email"
+	fieldName     = "name"
+	fieldFamily   = "family"
+	fieldVersion  = "version"
+	fieldOS       = "os"
+	fieldDevice   = "device"
+)
+
+// isBrowserField reports whether conditions on the field test browsers
+func isBrowserField(field string) bool {
+	switch field {
+	case fieldBrowsers, fieldFamily, fieldVersion, fieldOS, fieldDevice:
+		return true
+	}
+	return false
+}
+
+// queryNode is the parsed form of a query, left is the operand of NOT. The
+// test of UserAgent fields takes the raw browser.
+type queryNode struct {
+	kind        nodeKind
+	left, right *queryNode
+	field       string
+	test        func(string) bool
+}
+
+const defaultQuery = `browsers contains "Android" AND browsers contains "MSIE"`
+
+var DefaultQuery = MustParseQuery(defaultQuery)
+
+func (q *Query) String() string {
+	return q.source
+}
+
+// Match reports whether the user satisfies the query.
+func (q *Query) Match(user *userProfile) bool {
+	return q.match(user)
+}
+
+// CountsBrowser reports whether the browser satisfies any positive browsers
+// condition of the query, negated conditions exclude browsers rather than
+// select them.
+func (q *Query) CountsBrowser(browser string) bool {
+	for _, match := range q.browsers {
+		if match(browser) {
+			return true
+		}
+	}
+	return false
+}
+
+func MustParseQuery(s string) *Query {
+	q, err := ParseQuery(s)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func ParseQuery(s string) (*Query, error) {
+	tokens, err := tokenizeQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("query: unexpected %s at %d", tok, tok.pos)
+	}
+	q := &Query{source: s, root: root}
+	q.match = q.compile(root, false)
+	return q, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenLParen
+	tokenRParen
+)
+
+type queryToken struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t queryToken) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenString:
+		return strconv.Quote(t.value)
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+func tokenizeQuery(s string) ([]queryToken, error) {
+	tokens := make([]queryToken, 0)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, queryToken{kind: tokenLParen, value: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, queryToken{kind: tokenRParen, value: ")", pos: i})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("query: unterminated string at %d", i)
+			}
+			value, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("query: bad string at %d: %v", i, err)
+			}
+			tokens = append(tokens, queryToken{kind: tokenString, value: value, pos: i})
+			i = end + 1
+		default:
+			end := i
+			for ; end < len(s) && strings.IndexByte(" \t\r\n()\"", s[end]) < 0; end++ {
+			}
+			tokens = append(tokens, queryToken{kind: tokenWord, value: s[i:end], pos: i})
+			i = end
+		}
+	}
+	return append(tokens, queryToken{kind: tokenEOF, pos: len(s)}), nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *queryParser) keyword(word string) bool {
+	tok := p.peek()
+	if tok.kind == tokenWord && strings.EqualFold(tok.value, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseOr() (*queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &queryNode{kind: nodeOr, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (*queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &queryNode{kind: nodeAnd, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseUnary() (*queryNode, error) {
+	if p.keyword("NOT") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &queryNode{kind: nodeNot, left: inner}, nil
+	}
+
+	if p.peek().kind == tokenLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.kind != tokenRParen {
+			return nil, fmt.Errorf("query: expected \")\" at %d, got %s", tok.pos, tok)
+		}
+		return inner, nil
+	}
+
+	return p.parseCondition()
+}
+
+func (p *queryParser) parseCondition() (*queryNode, error) {
+	field := p.next()
+	if field.kind != tokenWord {
+		return nil, fmt.Errorf("query: expected field at %d, got %s", field.pos, field)
+	}
+	op := p.next()
+	if op.kind != tokenWord {
+		return nil, fmt.Errorf("query: expected operator at %d, got %s", op.pos, op)
+	}
+	arg := p.next()
+	if arg.kind != tokenString {
+		return nil, fmt.Errorf("query: expected string at %d, got %s", arg.pos, arg)
+	}
+
+	test, err := stringTest(op, arg.value)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.ToLower(field.value)
+	switch name {
+	case fieldBrowsers, fieldEmail, fieldName:
+		return &queryNode{kind: nodeCond, field: name, test: test}, nil
+	case fieldFamily, fieldVersion, fieldOS, fieldDevice:
+		uaTest := func(browser string) bool {
+			return test(userAgents.get(browser).field(name))
+		}
+		return &queryNode{kind: nodeCond, field: name, test: uaTest}, nil
+	}
+	return nil, fmt.Errorf("query: unknown field %s at %d", field, field.pos)
+}
+
+// compile turns the node into a matcher and collects browsers conditions
+// that are not negated, under an odd number of NOTs
+func (q *Query) compile(n *queryNode, negated bool) func(*userProfile) bool {
+	switch n.kind {
+	case nodeAnd:
+		left, right := q.compile(n.left, negated), q.compile(n.right, negated)
+		return func(u *userProfile) bool { return left(u) && right(u) }
+	case nodeOr:
+		left, right := q.compile(n.left, negated), q.compile(n.right, negated)
+		return func(u *userProfile) bool { return left(u) || right(u) }
+	case nodeNot:
+		inner := q.compile(n.left, !negated)
+		return func(u *userProfile) bool { return !inner(u) }
+	}
+
+	test := n.test
+	switch {
+	case isBrowserField(n.field):
+		if !negated {
+			q.browsers = append(q.browsers, test)
+		}
+		return func(u *userProfile) bool {
+			for _, browser := range u.Browsers {
+				if test(browser) {
+					return true
+				}
+			}
+			return false
+		}
+	case n.field == fieldEmail:
+		return func(u *userProfile) bool { return test(u.Email) }
+	default:
+		return func(u *userProfile) bool { return test(u.Name) }
+	}
+}
+
+func stringTest(op queryToken, arg string) (func(string) bool, error) {
+	switch strings.ToLower(op.value) {
+	case "contains":
+		return func(s string) bool { return strings.Contains(s, arg) }, nil
+	case "equals":
+		return func(s string) bool { return s == arg }, nil
+	case "startswith":
+		return func(s string) bool { return strings.HasPrefix(s, arg) }, nil
+	case "endswith":
+		return func(s string) bool { return strings.HasSuffix(s, arg) }, nil
+	}
+	return nil, fmt.Errorf("query: unknown operator %s at %d", op, op.pos)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQueryMatch(t *testing.T) {
+	user := &userProfile{
+		Browsers: []string{"Mozilla/5.0 (Linux; Android 4.4.2)", "Mozilla/4.0 (compatible; MSIE 7.0)"},
+		Email:    "jane@example.com",
+		Name:     "Jane Doe",
+	}
+
+	cases := []struct {
+		query string
+		match bool
+	}{
+		{defaultQuery, true},
+		{`browsers contains "Android" AND browsers contains "Opera"`, false},
+		{`browsers contains "Android" AND email endswith ".com"`, true},
+		{`browsers contains "Opera" OR name startswith "Jane"`, true},
+		{`NOT email endswith ".org"`, true},
+		{`name equals "Jane"`, false},
+		{`name equals "Jane Doe" and not (email contains "@example.org" or browsers contains "Opera")`, true},
+		{`(browsers contains "Opera" OR browsers contains "MSIE") AND name contains "\"" `, false},
+	}
+	for _, c := range cases {
+		q, err := ParseQuery(c.query)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.query, err)
+			continue
+		}
+		if q.Match(user) != c.match {
+			t.Errorf("%s: expected match %v", c.query, c.match)
+		}
+	}
+}
+
+func TestQueryCountsBrowser(t *testing.T) {
+	q := MustParseQuery(`(browsers contains "Android" OR email contains "x") AND NOT browsers startswith "Opera"`)
+	for browser, counted := range map[string]bool{
+		"Android":       true,
+		"Opera Android": true,
+		"Opera Mini":    false,
+		"MSIE":          false,
+	} {
+		if q.CountsBrowser(browser) != counted {
+			t.Errorf("%s: expected counted %v", browser, counted)
+		}
+	}
+}
+
+func TestQueryCountsBrowserNested(t *testing.T) {
+	// the second NOT makes the condition positive again
+	q := MustParseQuery(`NOT (name equals "x" OR NOT browsers contains "MSIE") AND NOT browsers contains "Opera"`)
+	for browser, counted := range map[string]bool{
+		"MSIE 7.0":   true,
+		"Opera MSIE": true,
+		"Opera":      false,
+	} {
+		if q.CountsBrowser(browser) != counted {
+			t.Errorf("%s: expected counted %v", browser, counted)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		``,
+		`browsers`,
+		`browsers contains`,
+		`browsers contains Android`,
+		`browsers has "Android"`,
+		`phone contains "1"`,
+		`(name equals "a"`,
+		`name equals "a")`,
+		`name equals "a" AND`,
+		`name equals "a`,
+		`name equals "a" name equals "b"`,
+	} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("%q: expected error", query)
+		}
+	}
+}
+
+func TestQueryMatchAllocs(t *testing.T) {
+	user := &userProfile{
+		Browsers: []string{"Mozilla/5.0 (Linux; Android 4.4.2)", "Mozilla/4.0 (compatible; MSIE 7.0)"},
+		Email:    "jane@example.com",
+	}
+	q := MustParseQuery(defaultQuery + ` AND email endswith ".com"`)
+	allocs := testing.AllocsPerRun(100, func() {
+		q.Match(user)
+		q.CountsBrowser(user.Browsers[0])
+	})
+	if allocs != 0 {
+		t.Errorf("query matching allocates: %v allocs", allocs)
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	out := new(bytes.Buffer)
+	FastSearchQuery(out, MustParseQuery(defaultQuery+` AND email endswith ".com"`))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) < 3 || lines[0] != "found users:" {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+	for _, line := range lines[1 : len(lines)-2] {
+		if !strings.HasSuffix(line, ".com>") {
+			t.Errorf("user does not match query: %s", line)
+		}
+	}
+
+	all := new(bytes.Buffer)
+	FastSearch(all)
+	if !strings.HasSuffix(out.String(), "\nTotal unique browsers 114\n") || out.Len() >= all.Len() {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
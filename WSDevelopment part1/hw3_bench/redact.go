@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type RedactionPolicy string
+
+const (
+	RedactNone    RedactionPolicy = "none"
+	RedactAt      RedactionPolicy = "at"
+	RedactPartial RedactionPolicy = "partial"
+	RedactHash    RedactionPolicy = "hash"
+	RedactDrop    RedactionPolicy = "drop"
+)
+
+// redactionPolicies maps a policy to its function, drop has none as the
+// field is not printed at all. Hash is keyed, see Redaction.redactor.
+var redactionPolicies = map[RedactionPolicy]func(string) string{
+	RedactNone:    func(s string) string { return s },
+	RedactAt:      func(s string) string { return strings.ReplaceAll(s, "@", " [at] ") },
+	RedactPartial: maskPartial,
+	RedactHash:    nil,
+	RedactDrop:    nil,
+}
+
+// Redaction selects a policy per output field. Empty policies fall back to
+// DefaultRedaction.
+type Redaction struct {
+	Name  RedactionPolicy
+	Email RedactionPolicy
+	// HashKey keys the hash policy, which is refused without it: plain
+	// hashes of names and emails are reversed by hashing a list of guesses
+	HashKey string
+}
+
+// DefaultRedaction gives the original FastSearch output
+var DefaultRedaction = Redaction{Name: RedactNone, Email: RedactAt}
+
+// ParseRedaction parses a list like "email=partial,name=hash".
+func ParseRedaction(s string) (Redaction, error) {
+	r := DefaultRedaction
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return r, fmt.Errorf("redact: expected field=policy, got %q", pair)
+		}
+		policy := RedactionPolicy(pair[i+1:])
+		switch field := pair[:i]; field {
+		case "name":
+			r.Name = policy
+		case "email":
+			r.Email = policy
+		default:
+			return r, fmt.Errorf("redact: unknown field %q", field)
+		}
+	}
+	return r, r.Validate()
+}
+
+func (r Redaction) Validate() error {
+	for _, policy := range []RedactionPolicy{r.Name, r.Email} {
+		if _, ok := redactionPolicies[policy]; !ok && policy != "" {
+			return fmt.Errorf("redact: unknown policy %q", policy)
+		}
+	}
+	return nil
+}
+
+func (r Redaction) String() string {
+	r = r.withDefaults()
+	return fmt.Sprintf("name=%s,email=%s", r.Name, r.Email)
+}
+
+func (r Redaction) withDefaults() Redaction {
+	if r.Name == "" {
+		r.Name = DefaultRedaction.Name
+	}
+	if r.Email == "" {
+		r.Email = DefaultRedaction.Email
+	}
+	return r
+}
+
+// redactor applies a validated Redaction
+type redactor struct {
+	name, email func(string) string
+}
+
+func (r Redaction) redactor() (redactor, error) {
+	if err := r.Validate(); err != nil {
+		return redactor{}, err
+	}
+	r = r.withDefaults()
+	res := redactor{name: redactionPolicies[r.Name], email: redactionPolicies[r.Email]}
+	if r.Name == RedactHash || r.Email == RedactHash {
+		if r.HashKey == "" {
+			return redactor{}, fmt.Errorf("redact: hash policy needs a key")
+		}
+		hash := func(s string) string { return hashValue(r.HashKey, s) }
+		if r.Name == RedactHash {
+			res.name = hash
+		}
+		if r.Email == RedactHash {
+			res.email = hash
+		}
+	}
+	return res, nil
+}
+
+// maskPartial keeps the first letter of every word and of the email parts
+// and the top level domain: "john@domain.com" becomes "j***@d***.com".
+func maskPartial(s string) string {
+	if at := strings.LastIndexByte(s, '@'); at >= 0 {
+		domain := s[at+1:]
+		tld := ""
+		if dot := strings.LastIndexByte(domain, '.'); dot >= 0 {
+			domain, tld = domain[:dot], domain[dot:]
+		}
+		return maskWord(s[:at]) + "@" + maskWord(domain) + tld
+	}
+	words := strings.Fields(s)
+	for i, word := range words {
+		words[i] = maskWord(word)
+	}
+	return strings.Join(words, " ")
+}
+
+func maskWord(s string) string {
+	if s == "" {
+		return s
+	}
+	_, size := utf8.DecodeRuneInString(s)
+	return s[:size] + "***"
+}
+
+// hashValue is stable for a key so hashed users can still be joined
+func hashValue(key, s string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(s))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
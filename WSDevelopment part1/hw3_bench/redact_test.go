@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaskPartial(t *testing.T) {
+	cases := map[string]string{
+		"john@domain.com":  "j***@d***.com",
+		"a@localhost":      "a***@l***",
+		"@x.org":           "@x***.org",
+		"Jonathan Ramos":   "J*** R***",
+		"Ёлка Палкина":     "Ё*** П***",
+		"":                 "",
+		"agent@mail.co.uk": "a***@m***.uk",
+	}
+	for in, expected := range cases {
+		if got := maskPartial(in); got != expected {
+			t.Errorf("%q: expected %q, got %q", in, expected, got)
+		}
+	}
+}
+
+func TestParseRedaction(t *testing.T) {
+	r, err := ParseRedaction("email=partial, name=hash")
+	if err != nil || r != (Redaction{Name: RedactHash, Email: RedactPartial}) {
+		t.Errorf("unexpected redaction %v %v", r, err)
+	}
+	if r, err := ParseRedaction(""); err != nil || r != DefaultRedaction {
+		t.Errorf("expected default redaction, got %v %v", r, err)
+	}
+	for _, bad := range []string{"email", "phone=drop", "email=blur"} {
+		if _, err := ParseRedaction(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+const redactTestData = `{"browsers":["Android 4","MSIE 8"],"name":"John Smith","email":"john@domain.com"}
+{"browsers":["Firefox"],"name":"Jane","email":"jane@x.org"}
+{"browsers":["MSIE 8","Android 4"],"name":"Ann Lee","email":"ann@y.net"}
+`
+
+func searchOutput(t *testing.T, o Output) string {
+	out := new(bytes.Buffer)
+	_, err := Searcher{Output: o}.Search(context.Background(), strings.NewReader(redactTestData), out, *DefaultQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the index must print the same
+	dir := t.TempDir()
+	source := filepath.Join(dir, "users.txt")
+	if err := ioutil.WriteFile(source, []byte(redactTestData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, _, err := OpenIndex(context.Background(), filepath.Join(dir, "users.idx"), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexOut := new(bytes.Buffer)
+	if _, err := idx.SearchOutput(context.Background(), indexOut, DefaultQuery, o); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != indexOut.String() {
+		t.Errorf("index output differs\nGot:\n%s\nExpected:\n%s", indexOut, out)
+	}
+	return out.String()
+}
+
+func TestSearchRedaction(t *testing.T) {
+	cases := []struct {
+		redaction Redaction
+		expected  string
+	}{
+		{Redaction{}, "[0] John Smith <john [at] domain.com>\n[2] Ann Lee <ann [at] y.net>\n"},
+		{Redaction{Email: RedactNone}, "[0] John Smith <john@domain.com>\n[2] Ann Lee <ann@y.net>\n"},
+		{Redaction{Name: RedactPartial, Email: RedactPartial}, "[0] J*** S*** <j***@d***.com>\n[2] A*** L*** <a***@y***.net>\n"},
+		{Redaction{Name: RedactDrop}, "[0] <john [at] domain.com>\n[2] <ann [at] y.net>\n"},
+		{Redaction{Email: RedactDrop}, "[0] John Smith\n[2] Ann Lee\n"},
+		{Redaction{Name: RedactDrop, Email: RedactDrop}, "[0]\n[2]\n"},
+		{Redaction{Email: RedactHash, HashKey: "k"}, "[0] John Smith <" + hashValue("k", "john@domain.com") + ">\n[2] Ann Lee <" + hashValue("k", "ann@y.net") + ">\n"},
+	}
+	for _, c := range cases {
+		expected := "found users:\n" + c.expected + "\nTotal unique browsers 2\n"
+		if got := searchOutput(t, Output{Redaction: c.redaction}); got != expected {
+			t.Errorf("%v: unexpected output\nGot:\n%s\nExpected:\n%s", c.redaction, got, expected)
+		}
+	}
+}
+
+func TestHashRedaction(t *testing.T) {
+	if got := hashValue("key", "The quick brown fox jumps over the lazy dog"); got != "hmac:f7bc83f430538424" {
+		t.Errorf("unexpected hash %s", got)
+	}
+	if hashValue("a", "john@domain.com") == hashValue("b", "john@domain.com") {
+		t.Error("hash does not depend on the key")
+	}
+
+	o := Output{Redaction: Redaction{Name: RedactHash}}
+	_, err := Searcher{Output: o}.Search(context.Background(), strings.NewReader(redactTestData), new(bytes.Buffer), *DefaultQuery)
+	if err == nil {
+		t.Error("hash policy accepted without a key")
+	}
+}
+
+func TestSearchJSON(t *testing.T) {
+	var result struct {
+		Users []struct {
+			ID    int     `json:"id"`
+			Name  *string `json:"name"`
+			Email *string `json:"email"`
+		} `json:"users"`
+		UniqueBrowsers int `json:"unique_browsers"`
+	}
+
+	data := searchOutput(t, Output{Format: "json", Redaction: Redaction{Name: RedactDrop, Email: RedactPartial}})
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		t.Fatalf("invalid json %q: %v", data, err)
+	}
+	if len(result.Users) != 2 || result.UniqueBrowsers != 2 {
+		t.Fatalf("unexpected result %s", data)
+	}
+	if u := result.Users[1]; u.ID != 2 || u.Name != nil || u.Email == nil || *u.Email != "a***@y***.net" {
+		t.Errorf("unexpected user %s", data)
+	}
+
+	empty := new(bytes.Buffer)
+	Searcher{Output: Output{Format: "json"}}.Search(context.Background(), strings.NewReader(""), empty, *DefaultQuery)
+	if err := json.Unmarshal(empty.Bytes(), &result); err != nil || len(result.Users) != 0 {
+		t.Errorf("unexpected empty result %q: %v", empty, err)
+	}
+
+	if _, err := (Searcher{Output: Output{Format: "xml"}}).Search(context.Background(), strings.NewReader(""), empty, *DefaultQuery); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go test -run Regression -regress
+// go test -run Regression -regress -regress.datasets "users=50000,android=0.5" -regress.update
+
+var (
+	regress          = flag.Bool("regress", false, "run the SlowSearch/FastSearch regression harness")
+	regressDatasets  = flag.String("regress.datasets", "users=1000;users=10000;users=10000,android=0.4,msie=0.3", "semicolon separated dataset `configs`")
+	regressHistory   = flag.String("regress.history", "testdata/bench_history.json", "benchmark history `file`")
+	regressThreshold = flag.Float64("regress.threshold", 0.2, "allowed FastSearch regression against the baseline")
+	regressUpdate    = flag.Bool("regress.update", false, "store this run as the new baseline")
+)
+
+type BenchResult struct {
+	NsPerOp     int64 `json:"ns_per_op"`
+	BytesPerOp  int64 `json:"bytes_per_op"`
+	AllocsPerOp int64 `json:"allocs_per_op"`
+}
+
+type DatasetResult struct {
+	Dataset string      `json:"dataset"`
+	Slow    BenchResult `json:"slow"`
+	Fast    BenchResult `json:"fast"`
+}
+
+type BenchRun struct {
+	Time      time.Time       `json:"time"`
+	GoVersion string          `json:"go_version"`
+	Results   []DatasetResult `json:"results"`
+}
+
+// BenchHistory keeps every run and the baseline per dataset the runs are
+// compared against.
+type BenchHistory struct {
+	Baseline map[string]DatasetResult `json:"baseline"`
+	Runs     []BenchRun               `json:"runs"`
+}
+
+func loadBenchHistory(path string) (*BenchHistory, error) {
+	history := &BenchHistory{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		data, err = []byte("{}"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, history); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if history.Baseline == nil {
+		history.Baseline = make(map[string]DatasetResult)
+	}
+	return history, nil
+}
+
+func (h *BenchHistory) save(path string) error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// regressions compares FastSearch against the baseline. Time is compared
+// relative to SlowSearch on the same machine, memory as is.
+func regressions(base, cur DatasetResult, threshold float64) []string {
+	var res []string
+	check := func(what string, was, now float64) {
+		if was > 0 && now > was*(1+threshold) {
+			res = append(res, fmt.Sprintf("%s: %s %.4g -> %.4g (+%.0f%%)",
+				cur.Dataset, what, was, now, (now/was-1)*100))
+		}
+	}
+	if base.Slow.NsPerOp > 0 && cur.Slow.NsPerOp > 0 {
+		check("fast/slow time",
+			float64(base.Fast.NsPerOp)/float64(base.Slow.NsPerOp),
+			float64(cur.Fast.NsPerOp)/float64(cur.Slow.NsPerOp))
+	}
+	check("B/op", float64(base.Fast.BytesPerOp), float64(cur.Fast.BytesPerOp))
+	check("allocs/op", float64(base.Fast.AllocsPerOp), float64(cur.Fast.AllocsPerOp))
+	return res
+}
+
+func withFilePath(path string, f func()) {
+	old := filePath
+	filePath = path
+	defer func() { filePath = old }()
+	f()
+}
+
+func benchSearch(search func(out *bytes.Buffer)) BenchResult {
+	out := new(bytes.Buffer)
+	r := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			out.Reset()
+			search(out)
+		}
+	})
+	return BenchResult{NsPerOp: r.NsPerOp(), BytesPerOp: r.AllocedBytesPerOp(), AllocsPerOp: r.AllocsPerOp()}
+}
+
+func runDatasetBench(t *testing.T, dir string, config DatasetConfig) DatasetResult {
+	path := filepath.Join(dir, fmt.Sprintf("users_%d.txt", config.Seed))
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateUsers(file, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	result := DatasetResult{Dataset: config.String()}
+	withFilePath(path, func() {
+		slowOut, fastOut := new(bytes.Buffer), new(bytes.Buffer)
+		SlowSearch(slowOut)
+		FastSearch(fastOut)
+		if slowOut.String() != fastOut.String() {
+			t.Fatalf("%s: results not match", result.Dataset)
+		}
+		result.Slow = benchSearch(func(out *bytes.Buffer) { SlowSearch(out) })
+		result.Fast = benchSearch(func(out *bytes.Buffer) { FastSearch(out) })
+	})
+	return result
+}
+
+func TestRegression(t *testing.T) {
+	if !*regress {
+		t.Skip("run with -regress")
+	}
+	history, err := loadBenchHistory(*regressHistory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := BenchRun{Time: time.Now().UTC(), GoVersion: runtime.Version()}
+	var failures []string
+	for _, spec := range strings.Split(*regressDatasets, ";") {
+		config, err := ParseDatasetConfig(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := runDatasetBench(t, t.TempDir(), config)
+		run.Results = append(run.Results, result)
+		t.Logf("%s: slow %d ns/op %d B/op %d allocs/op, fast %d ns/op %d B/op %d allocs/op",
+			result.Dataset, result.Slow.NsPerOp, result.Slow.BytesPerOp, result.Slow.AllocsPerOp,
+			result.Fast.NsPerOp, result.Fast.BytesPerOp, result.Fast.AllocsPerOp)
+
+		base, ok := history.Baseline[result.Dataset]
+		if ok {
+			failures = append(failures, regressions(base, result, *regressThreshold)...)
+		}
+		if !ok || *regressUpdate {
+			history.Baseline[result.Dataset] = result
+		}
+	}
+
+	history.Runs = append(history.Runs, run)
+	if err := history.save(*regressHistory); err != nil {
+		t.Fatal(err)
+	}
+	for _, failure := range failures {
+		t.Errorf("FastSearch regressed on %s", failure)
+	}
+}
+
+func TestRegressions(t *testing.T) {
+	base := DatasetResult{
+		Dataset: "d",
+		Slow:    BenchResult{NsPerOp: 1000},
+		Fast:    BenchResult{NsPerOp: 100, BytesPerOp: 1000, AllocsPerOp: 10},
+	}
+
+	// twice slower machine
+	cur := DatasetResult{
+		Dataset: "d",
+		Slow:    BenchResult{NsPerOp: 2000},
+		Fast:    BenchResult{NsPerOp: 220, BytesPerOp: 1100, AllocsPerOp: 12},
+	}
+	if res := regressions(base, cur, 0.2); len(res) != 0 {
+		t.Errorf("unexpected regressions %v", res)
+	}
+
+	cur.Fast = BenchResult{NsPerOp: 300, BytesPerOp: 1300, AllocsPerOp: 20}
+	if res := regressions(base, cur, 0.2); len(res) != 3 {
+		t.Errorf("expected 3 regressions, got %v", res)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// CoOccurrence holds how many users have both browser families, the
+// diagonal is the number of users having the family.
+type CoOccurrence struct {
+	Families []string `json:"families"`
+	Matrix   [][]int  `json:"matrix"`
+}
+
+// Report aggregates a users file. Every browser, domain and family is
+// counted at most once per user.
+type Report struct {
+	Users        int          `json:"users"`
+	SkippedLines int          `json:"skipped_lines"`
+	Browsers     []Count      `json:"browsers"`
+	Domains      []Count      `json:"email_domains"`
+	Families     []Count      `json:"browser_families"`
+	CoOccurrence CoOccurrence `json:"cooccurrence"`
+}
+
+func familyNames() []string {
+	names := make([]string, 0, len(browserFamilies)+1)
+	for _, family := range browserFamilies {
+		names = append(names, family.name)
+	}
+	return append(names, otherFamily)
+}
+
+// counter counts strings decoded by decodeLine, so keys are copied
+type counter struct {
+	ids    map[string]int
+	counts []Count
+}
+
+func newCounter() *counter {
+	return &counter{ids: make(map[string]int)}
+}
+
+func (c *counter) add(name string) {
+	id, ok := c.ids[name]
+	if !ok {
+		name = strings.Clone(name)
+		id = len(c.counts)
+		c.ids[name] = id
+		c.counts = append(c.counts, Count{Name: name})
+	}
+	c.counts[id].Count++
+}
+
+// top returns counts by decreasing count, at most n of them if n > 0
+func (c *counter) top(n int) []Count {
+	res := append([]Count(nil), c.counts...)
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Name < res[j].Name
+	})
+	if n > 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// BuildReport reads users from r in a single pass. Browser and domain lists
+// are cut to the top most frequent entries if top > 0.
+func BuildReport(ctx context.Context, r io.Reader, top int, skipBadLines bool) (*Report, error) {
+	report := &Report{}
+	browsers, domains := newCounter(), newCounter()
+	families := familyNames()
+	familyCounts := make([]int, len(families))
+	matrix := make([][]int, len(families))
+	for i := range matrix {
+		matrix[i] = make([]int, len(families))
+	}
+
+	scanner := bufio.NewScanner(r)
+	user := &userProfile{}
+	seen := make([]string, 0, 8)
+	userFamilies := make([]bool, len(families))
+
+	for line := 0; scanner.Scan(); line++ {
+		if line%searchCtxCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		user.reset()
+		if err := user.decodeLine(scanner.Bytes()); err != nil {
+			if skipBadLines {
+				report.SkippedLines++
+				continue
+			}
+			return nil, &LineError{Line: line + 1, Err: err}
+		}
+		report.Users++
+
+		seen = seen[:0]
+		for i := range userFamilies {
+			userFamilies[i] = false
+		}
+		for _, browser := range user.Browsers {
+			if containsString(seen, browser) {
+				continue
+			}
+			seen = append(seen, browser)
+			browsers.add(browser)
+			userFamilies[browserFamily(browser)] = true
+		}
+
+		for i, has := range userFamilies {
+			if !has {
+				continue
+			}
+			familyCounts[i]++
+			for j, other := range userFamilies {
+				if other {
+					matrix[i][j]++
+				}
+			}
+		}
+
+		if at := strings.LastIndexByte(user.Email, '@'); at >= 0 {
+			domains.add(user.Email[at+1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	report.Browsers = browsers.top(top)
+	report.Domains = domains.top(top)
+	for i, name := range families {
+		report.Families = append(report.Families, Count{Name: name, Count: familyCounts[i]})
+	}
+	sort.SliceStable(report.Families, func(i, j int) bool {
+		return report.Families[i].Count > report.Families[j].Count
+	})
+	report.CoOccurrence = CoOccurrence{Families: families, Matrix: matrix}
+	return report, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes rows of section,name,other,count, where other is the
+// second family of co-occurrence rows.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"section", "name", "other", "count"})
+	cw.Write([]string{"users", "", "", strconv.Itoa(r.Users)})
+	for _, section := range r.sections() {
+		for _, c := range section.counts {
+			cw.Write([]string{section.key, c.Name, "", strconv.Itoa(c.Count)})
+		}
+	}
+	for i, family := range r.CoOccurrence.Families {
+		for j, other := range r.CoOccurrence.Families {
+			cw.Write([]string{"cooccurrence", family, other, strconv.Itoa(r.CoOccurrence.Matrix[i][j])})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Users: %d\n", r.Users)
+	if r.SkippedLines > 0 {
+		fmt.Fprintf(tw, "Skipped lines: %d\n", r.SkippedLines)
+	}
+	for _, section := range r.sections() {
+		fmt.Fprintf(tw, "\n%s\n", section.title)
+		for _, c := range section.counts {
+			fmt.Fprintf(tw, "%d\t%s\n", c.Count, c.Name)
+		}
+	}
+
+	fmt.Fprintln(tw, "\nBrowser family co-occurrence")
+	fmt.Fprint(tw, "\t", strings.Join(r.CoOccurrence.Families, "\t"), "\n")
+	for i, family := range r.CoOccurrence.Families {
+		fmt.Fprint(tw, family)
+		for _, n := range r.CoOccurrence.Matrix[i] {
+			fmt.Fprint(tw, "\t", n)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+type reportSection struct {
+	key    string
+	title  string
+	counts []Count
+}
+
+func (r *Report) sections() []reportSection {
+	return []reportSection{
+		{"browser", "Browsers", r.Browsers},
+		{"domain", "Email domains", r.Domains},
+		{"family", "Browser families", r.Families},
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const reportTestData = `{"browsers":["Mozilla/5.0 (Linux; Android 4.4) Chrome/30.0","Mozilla/4.0 (compatible; MSIE 8.0)","Mozilla/4.0 (compatible; MSIE 8.0)"],"email":"a@x.com"}
+{"browsers":["Mozilla/4.0 (compatible; MSIE 8.0)","Lynx/2.8"],"email":"b@y.org"}
+{"browsers":["Mozilla/5.0 Chrome/41.0 Safari/537.36"],"email":"c@x.com"}
+`
+
+func TestBuildReport(t *testing.T) {
+	report, err := BuildReport(context.Background(), strings.NewReader(reportTestData), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Users != 3 {
+		t.Errorf("expected 3 users, got %d", report.Users)
+	}
+	expectedBrowsers := []Count{
+		{"Mozilla/4.0 (compatible; MSIE 8.0)", 2},
+		{"Lynx/2.8", 1},
+		{"Mozilla/5.0 (Linux; Android 4.4) Chrome/30.0", 1},
+		{"Mozilla/5.0 Chrome/41.0 Safari/537.36", 1},
+	}
+	if !reflect.DeepEqual(report.Browsers, expectedBrowsers) {
+		t.Errorf("unexpected browsers %v", report.Browsers)
+	}
+	if expected := []Count{{"x.com", 2}, {"y.org", 1}}; !reflect.DeepEqual(report.Domains, expected) {
+		t.Errorf("unexpected domains %v", report.Domains)
+	}
+
+	families := map[string]int{}
+	for _, c := range report.Families {
+		families[c.Name] = c.Count
+	}
+	if families["MSIE"] != 2 || families["Android"] != 1 || families["Chrome"] != 1 || families[otherFamily] != 1 {
+		t.Errorf("unexpected families %v", report.Families)
+	}
+
+	co := report.CoOccurrence
+	pos := map[string]int{}
+	for i, name := range co.Families {
+		pos[name] = i
+	}
+	if co.Matrix[pos["MSIE"]][pos["Android"]] != 1 || co.Matrix[pos["MSIE"]][pos[otherFamily]] != 1 ||
+		co.Matrix[pos["Chrome"]][pos["MSIE"]] != 0 || co.Matrix[pos["MSIE"]][pos["MSIE"]] != 2 {
+		t.Errorf("unexpected co-occurrence %v", co.Matrix)
+	}
+
+	top, err := BuildReport(context.Background(), strings.NewReader(reportTestData), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top.Browsers) != 1 || len(top.Domains) != 1 {
+		t.Errorf("top not applied: %v %v", top.Browsers, top.Domains)
+	}
+}
+
+func TestReportFormats(t *testing.T) {
+	report, err := BuildReport(context.Background(), strings.NewReader(reportTestData), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+	if err := report.WriteJSON(out); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Report{}
+	if err := json.Unmarshal(out.Bytes(), decoded); err != nil || !reflect.DeepEqual(decoded, report) {
+		t.Errorf("json report does not round trip: %v", err)
+	}
+
+	out.Reset()
+	if err := report.WriteCSV(out); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	families := len(report.CoOccurrence.Families)
+	if expected := 2 + len(report.Browsers) + len(report.Domains) + families + families*families; len(rows) != expected {
+		t.Errorf("expected %d csv rows, got %d", expected, len(rows))
+	}
+
+	out.Reset()
+	if err := report.WriteTable(out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "2  x.com\n") {
+		t.Errorf("unexpected table:\n%s", out.String())
+	}
+}
+
+func TestReportBadLine(t *testing.T) {
+	data := reportTestData + "{\n"
+	if _, err := BuildReport(context.Background(), strings.NewReader(data), 0, false); err == nil {
+		t.Errorf("expected error for malformed line")
+	}
+	report, err := BuildReport(context.Background(), strings.NewReader(data), 0, true)
+	if err != nil || report.SkippedLines != 1 {
+		t.Errorf("malformed line not skipped: %v", err)
+	}
+}
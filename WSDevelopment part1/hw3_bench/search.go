@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type Stats struct {
+	Lines          int
+	Matched        int
+	UniqueBrowsers int
+	SkippedLines   int
+}
+
+// LineError reports a line of the input that could not be decoded.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Searcher prints users matching a query in the FastSearch format.
+type Searcher struct {
+	// SkipBadLines makes malformed lines count in Stats.SkippedLines
+	// instead of aborting the search.
+	SkipBadLines bool
+	Output
+}
+
+// how many lines are scanned between context checks
+const searchCtxCheckLines = 256
+
+func Search(ctx context.Context, r io.Reader, w io.Writer, q Query) (Stats, error) {
+	return Searcher{}.Search(ctx, r, w, q)
+}
+
+// Search reads one JSON user per line from r. Users are numbered by their
+// line, starting from 0.
+func (s Searcher) Search(ctx context.Context, r io.Reader, w io.Writer, q Query) (Stats, error) {
+	stats := Stats{}
+	scanner := bufio.NewScanner(r)
+	seenBrowsers := make([]string, 0, 100)
+	user := &userProfile{}
+
+	out, err := s.Output.writer(w)
+	if err != nil {
+		return stats, err
+	}
+	if err := out.begin(); err != nil {
+		return stats, err
+	}
+
+	for ; scanner.Scan(); stats.Lines++ {
+		if stats.Lines%searchCtxCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return stats, err
+			}
+		}
+
+		user.reset()
+		if err := user.decodeLine(scanner.Bytes()); err != nil {
+			if s.SkipBadLines {
+				stats.SkippedLines++
+				continue
+			}
+			return stats, &LineError{Line: stats.Lines + 1, Err: err}
+		}
+
+		for _, browser := range user.Browsers {
+			if !q.CountsBrowser(browser) {
+				continue
+			}
+			key := s.UniqueBy.key(browser)
+			notSeenBefore := true
+			for _, item := range seenBrowsers {
+				if item == key {
+					notSeenBefore = false
+					break
+				}
+			}
+			if notSeenBefore {
+				// the decoded browser points into the scanner buffer
+				seenBrowsers = append(seenBrowsers, strings.Clone(key))
+			}
+		}
+
+		if q.Match(user) {
+			stats.Matched++
+			if err := out.user(stats.Lines, user.Name, user.Email); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, &LineError{Line: stats.Lines + 1, Err: err}
+	}
+
+	stats.UniqueBrowsers = len(seenBrowsers)
+	return stats, out.end(stats.UniqueBrowsers)
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// OpenInput opens a file, an http(s) URL or stdin ("-") for Search. Gzip
+// compressed input is decompressed transparently.
+func OpenInput(ctx context.Context, name string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	switch {
+	case name == "-":
+		rc = os.Stdin
+	case strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://"):
+		req, err := http.NewRequest(http.MethodGet, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", name, resp.Status)
+		}
+		rc = resp.Body
+	default:
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		rc = file
+	}
+	return maybeGunzip(rc)
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	underlying io.Closer
+}
+
+func (g gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.underlying.Close()
+}
+
+type bufferedReadCloser struct {
+	*bufio.Reader
+	io.Closer
+}
+
+func maybeGunzip(rc io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return bufferedReadCloser{Reader: br, Closer: rc}, nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return gzipReadCloser{Reader: gz, underlying: rc}, nil
+}
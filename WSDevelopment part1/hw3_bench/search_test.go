@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const searchTestData = `{"browsers":["Android 4.4","MSIE 8.0"],"email":"a@b.com","name":"Anna"}
+{"browsers":["Opera"],"email":"c@d.com","name":"Carl"}
+{"browsers":["Android 4.4",
+{"browsers":["Android 5.0","MSIE 9.0"],"email":"e@f.com","name":"Eve"}
+`
+
+func TestSearchReader(t *testing.T) {
+	out := new(bytes.Buffer)
+	_, err := Search(context.Background(), strings.NewReader(searchTestData), out, *DefaultQuery)
+
+	lineErr := &LineError{}
+	if !errors.As(err, &lineErr) || lineErr.Line != 3 {
+		t.Fatalf("expected error on line 3, got %v", err)
+	}
+
+	out.Reset()
+	stats, err := Searcher{SkipBadLines: true}.Search(context.Background(), strings.NewReader(searchTestData), out, *DefaultQuery)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "found users:\n[0] Anna <a [at] b.com>\n[3] Eve <e [at] f.com>\n\nTotal unique browsers 4\n"
+	if out.String() != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+	if stats != (Stats{Lines: 4, Matched: 2, UniqueBrowsers: 4, SkippedLines: 1}) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSearchCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Search(ctx, strings.NewReader(searchTestData), ioutil.Discard, *DefaultQuery)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSearchGzipInput(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gzPath := filepath.Join(dir, "users.txt.gz")
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	gz.Write(data)
+	gz.Close()
+	if err := ioutil.WriteFile(gzPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	slowOut := new(bytes.Buffer)
+	SlowSearch(slowOut)
+
+	for _, name := range []string{filePath, gzPath} {
+		in, err := OpenInput(context.Background(), name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		out := new(bytes.Buffer)
+		_, err = Search(context.Background(), in, out, *DefaultQuery)
+		in.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if out.String() != slowOut.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", name, out.String(), slowOut.String())
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// UserAgent is a browser string split into fields. All fields point into
+// the parsed string or are constants, so parsing does not allocate.
+type UserAgent struct {
+	Family string
+	// Version is major.minor, empty if unknown
+	Version string
+	OS      string
+	// Device is desktop, mobile, tablet or bot
+	Device string
+}
+
+const (
+	deviceDesktop = "desktop"
+	deviceMobile  = "mobile"
+	deviceTablet  = "tablet"
+	deviceBot     = "bot"
+)
+
+// browser families in detection order: Edge and Opera pretend to be Chrome,
+// Chrome pretends to be Safari. versions lists where the family version
+// follows, the first found wins.
+var browserFamilies = []struct {
+	name     string
+	markers  []string
+	versions []string
+}{
+	{"Edge", []string{"Edge/", "Edg/"}, []string{"Edge/", "Edg/"}},
+	{"Opera", []string{"Opera", "OPR/"}, []string{"OPR/", "Opera Mini/", "Version/", "Opera/", "Opera "}},
+	{"MSIE", []string{"MSIE", "Trident/"}, []string{"MSIE ", "rv:"}},
+	{"Android", []string{"Android"}, []string{"Android ", "Android/"}},
+	{"Chrome", []string{"Chrome/", "CriOS/"}, []string{"Chrome/", "CriOS/"}},
+	{"Firefox", []string{"Firefox/"}, []string{"Firefox/"}},
+	{"Safari", []string{"Safari/"}, []string{"Version/", "Safari/"}},
+}
+
+const otherFamily = "Other"
+
+var operatingSystems = []struct {
+	name    string
+	markers []string
+}{
+	{"Windows Phone", []string{"Windows Phone"}},
+	{"Windows", []string{"Windows"}},
+	{"Android", []string{"Android"}},
+	{"iOS", []string{"iPhone", "iPad", "iPod"}},
+	{"Mac OS X", []string{"Mac OS X", "Macintosh"}},
+	{"Chrome OS", []string{"CrOS"}},
+	{"BSD", []string{"BSD"}},
+	{"Linux", []string{"Linux", "X11"}},
+	{"Symbian", []string{"Symbian", "Series60", "Series80"}},
+	{"J2ME", []string{"J2ME", "MIDP"}},
+}
+
+var (
+	botMarkers    = []string{"bot", "Bot", "spider", "Spider", "crawler", "Crawler", "http://", "https://"}
+	tabletMarkers = []string{"iPad", "Tablet", "Kindle"}
+	mobileMarkers = []string{"Mobile", "iPhone", "iPod", "J2ME", "MIDP", "Opera Mini", "Windows Phone", "Symbian"}
+)
+
+func containsAny(s string, markers []string) bool {
+	for _, marker := range markers {
+		if strings.Contains(s, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseUserAgent never fails, unknown parts are reported as Other or an
+// empty version.
+func ParseUserAgent(ua string) UserAgent {
+	res := UserAgent{Family: otherFamily, OS: otherFamily, Device: deviceDesktop}
+
+	if i := browserFamily(ua); i < len(browserFamilies) {
+		family := browserFamilies[i]
+		res.Family = family.name
+		for _, marker := range family.versions {
+			if pos := strings.Index(ua, marker); pos >= 0 {
+				if res.Version = majorMinor(ua[pos+len(marker):]); res.Version != "" {
+					break
+				}
+			}
+		}
+	}
+
+	for _, os := range operatingSystems {
+		if containsAny(ua, os.markers) {
+			res.OS = os.name
+			break
+		}
+	}
+
+	switch {
+	case containsAny(ua, botMarkers):
+		res.Device = deviceBot
+	case containsAny(ua, tabletMarkers):
+		res.Device = deviceTablet
+	case containsAny(ua, mobileMarkers):
+		res.Device = deviceMobile
+	case res.OS == "Android":
+		// Android browsers without Mobile are tablets
+		res.Device = deviceTablet
+	}
+	return res
+}
+
+// userAgents memoizes ParseUserAgent for queries and unique keys, which
+// look at every browser of every user while a users file has few distinct
+// browsers. It stops growing at userAgentsLimit entries.
+var userAgents = &userAgentCache{m: make(map[string]parsedAgent)}
+
+const userAgentsLimit = 1 << 14
+
+type userAgentCache struct {
+	mu sync.RWMutex
+	m  map[string]parsedAgent
+}
+
+type parsedAgent struct {
+	UserAgent
+	// versionKey is the UniqueVersion key
+	versionKey string
+}
+
+// get returns the parsed browser. The browser may point into a read buffer,
+// the parsed fields never do.
+func (c *userAgentCache) get(browser string) parsedAgent {
+	c.mu.RLock()
+	res, ok := c.m[browser]
+	c.mu.RUnlock()
+	if ok {
+		return res
+	}
+
+	browser = strings.Clone(browser)
+	res.UserAgent = ParseUserAgent(browser)
+	res.versionKey = res.Family
+	if res.Version != "" {
+		res.versionKey += " " + res.Version
+	}
+	c.mu.Lock()
+	if len(c.m) < userAgentsLimit {
+		c.m[browser] = res
+	}
+	c.mu.Unlock()
+	return res
+}
+
+func browserFamily(browser string) int {
+	for i, family := range browserFamilies {
+		if containsAny(browser, family.markers) {
+			return i
+		}
+	}
+	return len(browserFamilies)
+}
+
+// majorMinor returns the leading "12" or "12.3" of s, skipping "12.3.4"
+// and anything after it
+func majorMinor(s string) string {
+	end, dots := 0, 0
+	for ; end < len(s); end++ {
+		c := s[end]
+		if c == '.' {
+			if dots++; dots == 2 || end == 0 {
+				break
+			}
+			continue
+		}
+		if c < '0' || c > '9' {
+			break
+		}
+	}
+	return strings.TrimSuffix(s[:end], ".")
+}
+
+// field returns the query field of the user agent
+func (ua UserAgent) field(name string) string {
+	switch name {
+	case fieldFamily:
+		return ua.Family
+	case fieldVersion:
+		return ua.Version
+	case fieldOS:
+		return ua.OS
+	}
+	return ua.Device
+}
+
+// UniqueBy selects what makes browsers different when counting unique
+// browsers.
+type UniqueBy string
+
+const (
+	// UniqueBrowser counts raw browser strings, the FastSearch behaviour
+	UniqueBrowser UniqueBy = "browser"
+	UniqueFamily  UniqueBy = "family"
+	// UniqueVersion counts family and major.minor version pairs
+	UniqueVersion UniqueBy = "version"
+)
+
+func (u UniqueBy) Validate() error {
+	switch u {
+	case "", UniqueBrowser, UniqueFamily, UniqueVersion:
+		return nil
+	}
+	return fmt.Errorf("unknown unique browsers key %q", u)
+}
+
+// key returns the browser as counted for unique browsers
+func (u UniqueBy) key(browser string) string {
+	switch u {
+	case UniqueFamily:
+		return userAgents.get(browser).Family
+	case UniqueVersion:
+		return userAgents.get(browser).versionKey
+	}
+	return browser
+}
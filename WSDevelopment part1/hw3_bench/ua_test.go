@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		ua       string
+		expected UserAgent
+	}{
+		{"Mozilla/5.0 (Linux; U; Android 4.3; en-us; sdk Build/MR1) AppleWebKit/536.23 (KHTML, like Gecko) Version/4.3 Mobile Safari/536.23",
+			UserAgent{"Android", "4.3", "Android", deviceMobile}},
+		{"Mozilla/5.0 (Linux; U; Android 3.0.1; fr-fr; A500 Build/HRI66) AppleWebKit/534.13 (KHTML, like Gecko) Version/4.0 Safari/534.13",
+			UserAgent{"Android", "3.0", "Android", deviceTablet}},
+		{"Mozilla/4.0 (compatible; MSIE 8.0; Windows NT 6.1; Trident/4.0)",
+			UserAgent{"MSIE", "8.0", "Windows", deviceDesktop}},
+		{"Mozilla/5.0 (Windows NT 6.3; Trident/7.0; rv:11.0) like Gecko",
+			UserAgent{"MSIE", "11.0", "Windows", deviceDesktop}},
+		{"Opera/9.80 (X11; Linux i686) Presto/2.12.388 Version/12.16",
+			UserAgent{"Opera", "12.16", "Linux", deviceDesktop}},
+		{"Opera/9.80 (J2ME/MIDP; Opera Mini/8.0.35626/37.8918; U; en) Presto/2.12.423 Version/12.16",
+			UserAgent{"Opera", "8.0", "J2ME", deviceMobile}},
+		{"Mozilla/5.0 (X11; U; Linux x86_64; en-gb) AppleWebKit/534.35 (KHTML, like Gecko) Chrome/11.0.696.65 Safari/534.35 Puffin/2.9174AP",
+			UserAgent{"Chrome", "11.0", "Linux", deviceDesktop}},
+		{"Mozilla/5.0 (Windows NT 10.0; WOW64; rv:40.0) Gecko/20100101 Firefox/40.0",
+			UserAgent{"Firefox", "40.0", "Windows", deviceDesktop}},
+		{"Mozilla/5.0 (iPad; CPU OS 7_1_2 like Mac OS X) AppleWebKit/537.51.2 (KHTML, like Gecko) Version/7.0 Mobile/11D257 Safari/9537.53",
+			UserAgent{"Safari", "7.0", "iOS", deviceTablet}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_6_8) AppleWebKit/537.13+ (KHTML, like Gecko) Version/5.1.7 Safari/534.57.2",
+			UserAgent{"Safari", "5.1", "Mac OS X", deviceDesktop}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/70.0.3538.102 Safari/537.36 Edge/18.19582",
+			UserAgent{"Edge", "18.19582", "Windows", deviceDesktop}},
+		{"DoCoMo/2.0 N905i(c100;TB;W24H16) (compatible; Googlebot-Mobile/2.1;  http://www.google.com/bot.html)",
+			UserAgent{otherFamily, "", otherFamily, deviceBot}},
+		{"Mozilla/5.0 (X11; FreeBSD amd64; rv:40.0) Gecko/20100101 Firefox/40.0",
+			UserAgent{"Firefox", "40.0", "BSD", deviceDesktop}},
+		{"P3P Validator", UserAgent{otherFamily, "", otherFamily, deviceDesktop}},
+		{"", UserAgent{otherFamily, "", otherFamily, deviceDesktop}},
+	}
+	for _, c := range cases {
+		if got := ParseUserAgent(c.ua); got != c.expected {
+			t.Errorf("%q:\ngot      %+v\nexpected %+v", c.ua, got, c.expected)
+		}
+	}
+}
+
+func TestMajorMinor(t *testing.T) {
+	for in, expected := range map[string]string{
+		"12":        "12",
+		"12.3.4 x":  "12.3",
+		"4.0;":      "4.0",
+		"5.":        "5",
+		".5":        "",
+		"x":         "",
+		"11.0) abc": "11.0",
+	} {
+		if got := majorMinor(in); got != expected {
+			t.Errorf("%q: expected %q, got %q", in, expected, got)
+		}
+	}
+}
+
+func TestParseUserAgentAllocs(t *testing.T) {
+	lines := userLines(t)
+	user := &userProfile{}
+	if err := user.decodeLine(lines[0]); err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, browser := range user.Browsers {
+			ParseUserAgent(browser)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("parsing allocates: %v allocs", allocs)
+	}
+}
+
+func TestUserAgentCacheAllocs(t *testing.T) {
+	lines := userLines(t)
+	user := &userProfile{}
+	if err := user.decodeLine(lines[0]); err != nil {
+		t.Fatal(err)
+	}
+	q := MustParseQuery(`family equals "MSIE" OR version startswith "4." OR os equals "Linux"`)
+	allocs := testing.AllocsPerRun(100, func() {
+		q.Match(user)
+		for _, browser := range user.Browsers {
+			if q.CountsBrowser(browser) {
+				UniqueVersion.key(browser)
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("cached user agents allocate: %v allocs", allocs)
+	}
+
+	// decoded browsers point into the line, keys must outlive it
+	line := []byte(`{"browsers":["Mozilla/4.0 (compatible; MSIE 6.5; Windows NT 5.1)"],"name":"D","email":"d@x"}`)
+	if err := user.decodeLine(line); err != nil {
+		t.Fatal(err)
+	}
+	key := UniqueVersion.key(user.Browsers[0])
+	copy(line, bytes.Repeat([]byte("x"), len(line)))
+	if key != "MSIE 6.5" || UniqueVersion.key("Mozilla/4.0 (compatible; MSIE 6.5; Windows NT 5.1)") != key {
+		t.Errorf("unexpected key %q", key)
+	}
+}
+
+const uaTestData = `{"browsers":["Mozilla/4.0 (compatible; MSIE 8.0; Windows NT 6.1)","Mozilla/5.0 (Linux; U; Android 4.3) Version/4.3 Mobile Safari/536.23"],"name":"A","email":"a@x"}
+{"browsers":["Mozilla/4.0 (compatible; MSIE 8.0; Windows NT 5.1)","Mozilla/5.0 (Linux; U; Android 4.3.1) Mobile Safari/536.23"],"name":"B","email":"b@x"}
+{"browsers":["Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 5.1)","Mozilla/5.0 (Windows NT 10.0; rv:40.0) Gecko/20100101 Firefox/40.0"],"name":"C","email":"c@x"}
+`
+
+func TestSearchUserAgentFields(t *testing.T) {
+	cases := []struct {
+		query    string
+		unique   UniqueBy
+		expected string
+	}{
+		{`family equals "MSIE" AND family equals "Android"`, UniqueBrowser, "[0] A <a [at] x>\n[1] B <b [at] x>\n\nTotal unique browsers 5\n"},
+		{`family equals "MSIE" AND family equals "Android"`, UniqueFamily, "[0] A <a [at] x>\n[1] B <b [at] x>\n\nTotal unique browsers 2\n"},
+		{`family equals "MSIE"`, UniqueVersion, "[0] A <a [at] x>\n[1] B <b [at] x>\n[2] C <c [at] x>\n\nTotal unique browsers 2\n"},
+		{`family equals "MSIE" AND version startswith "7."`, UniqueFamily, "[2] C <c [at] x>\n\nTotal unique browsers 1\n"},
+		{`device equals "mobile" AND os equals "Android"`, UniqueVersion, "[0] A <a [at] x>\n[1] B <b [at] x>\n\nTotal unique browsers 1\n"},
+	}
+	for _, c := range cases {
+		q := MustParseQuery(c.query)
+		o := Output{UniqueBy: c.unique}
+		out := new(bytes.Buffer)
+		if _, err := (Searcher{Output: o}).Search(context.Background(), strings.NewReader(uaTestData), out, *q); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "found users:\n" + c.expected; out.String() != expected {
+			t.Errorf("%s by %s: unexpected output\nGot:\n%s\nExpected:\n%s", c.query, c.unique, out, expected)
+		}
+	}
+
+	if _, err := (Searcher{Output: Output{UniqueBy: "os"}}).Search(context.Background(), strings.NewReader(uaTestData), new(bytes.Buffer), *DefaultQuery); err == nil {
+		t.Errorf("expected error for unknown unique key")
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// same limit as encoding/json
+const maxNestingDepth = 10000
+
+// decodeLine decodes a JSON object into u the way encoding/json would,
+// reading only browsers, email and name and skipping other keys.
+//
+// It does not allocate per field: strings point into data, or into a
+// buffer kept in u if they had to be unescaped, so they are valid only
+// until data changes or decodeLine is called again. Copy them to keep.
+func (u *userProfile) decodeLine(data []byte) error {
+	u.scratch = u.scratch[:0]
+	s := &userScanner{data: data, u: u}
+
+	s.skipSpace()
+	if s.pos < len(data) && data[s.pos] == '{' {
+		if err := s.readProfile(); err != nil {
+			return err
+		}
+	} else {
+		isNull := bytes.HasPrefix(data[s.pos:], []byte("null"))
+		if err := s.skipValue(0); err != nil {
+			return err
+		}
+		if !isNull {
+			return s.errorf("cannot decode non-object value into userProfile")
+		}
+	}
+
+	s.skipSpace()
+	if s.pos != len(data) {
+		return s.errorf("invalid character %q after top-level value", data[s.pos])
+	}
+	return nil
+}
+
+// reset clears the profile keeping its buffers
+func (u *userProfile) reset() {
+	u.Browsers = u.Browsers[:0]
+	u.Email = ""
+	u.Name = ""
+}
+
+type userScanner struct {
+	data []byte
+	pos  int
+	u    *userProfile
+}
+
+func (s *userScanner) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("userProfile: "+format+" at offset %d", append(args, s.pos)...)
+}
+
+func (s *userScanner) unexpected() error {
+	if s.pos >= len(s.data) {
+		return s.errorf("unexpected end of JSON input")
+	}
+	return s.errorf("invalid character %q", s.data[s.pos])
+}
+
+func (s *userScanner) skipSpace() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (s *userScanner) consume(c byte) bool {
+	if s.pos < len(s.data) && s.data[s.pos] == c {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+func (s *userScanner) literal(lit string) bool {
+	if len(s.data)-s.pos >= len(lit) && string(s.data[s.pos:s.pos+len(lit)]) == lit {
+		s.pos += len(lit)
+		return true
+	}
+	return false
+}
+
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+func (s *userScanner) readProfile() error {
+	s.pos++ // {
+	s.skipSpace()
+	if s.consume('}') {
+		return nil
+	}
+	for {
+		if s.pos >= len(s.data) || s.data[s.pos] != '"' {
+			return s.unexpected()
+		}
+		key, err := s.readString()
+		if err != nil {
+			return err
+		}
+		s.skipSpace()
+		if !s.consume(':') {
+			return s.unexpected()
+		}
+		s.skipSpace()
+
+		switch {
+		case bytes.EqualFold(key, []byte("browsers")):
+			err = s.readBrowsers()
+		case bytes.EqualFold(key, []byte("email")):
+			err = s.readStringField(&s.u.Email)
+		case bytes.EqualFold(key, []byte("name")):
+			err = s.readStringField(&s.u.Name)
+		default:
+			err = s.skipValue(1)
+		}
+		if err != nil {
+			return err
+		}
+
+		s.skipSpace()
+		if s.consume('}') {
+			return nil
+		}
+		if !s.consume(',') {
+			return s.unexpected()
+		}
+		s.skipSpace()
+	}
+}
+
+// readStringField leaves the field unchanged for null
+func (s *userScanner) readStringField(field *string) error {
+	if s.literal("null") {
+		return nil
+	}
+	if s.pos >= len(s.data) || s.data[s.pos] != '"' {
+		if err := s.skipValue(1); err != nil {
+			return err
+		}
+		return s.errorf("cannot decode non-string value into string field")
+	}
+	val, err := s.readString()
+	if err != nil {
+		return err
+	}
+	*field = bytesToString(val)
+	return nil
+}
+
+func (s *userScanner) readBrowsers() error {
+	u := s.u
+	if s.literal("null") {
+		u.Browsers = nil
+		return nil
+	}
+	if !s.consume('[') {
+		if err := s.skipValue(1); err != nil {
+			return err
+		}
+		return s.errorf("cannot decode non-array value into browsers")
+	}
+
+	if u.Browsers == nil {
+		u.Browsers = []string{}
+	} else {
+		u.Browsers = u.Browsers[:0]
+	}
+
+	s.skipSpace()
+	if s.consume(']') {
+		return nil
+	}
+	for {
+		switch {
+		case s.literal("null"):
+			u.Browsers = append(u.Browsers, "")
+		case s.pos < len(s.data) && s.data[s.pos] == '"':
+			val, err := s.readString()
+			if err != nil {
+				return err
+			}
+			u.Browsers = append(u.Browsers, bytesToString(val))
+		default:
+			if err := s.skipValue(2); err != nil {
+				return err
+			}
+			return s.errorf("cannot decode non-string value into browsers element")
+		}
+
+		s.skipSpace()
+		if s.consume(']') {
+			return nil
+		}
+		if !s.consume(',') {
+			return s.unexpected()
+		}
+		s.skipSpace()
+	}
+}
+
+// readString reads the string at s.pos. Strings without escapes and with
+// valid UTF-8 are returned as a subslice of the input.
+func (s *userScanner) readString() ([]byte, error) {
+	start := s.pos + 1
+	ascii := true
+	for i := start; i < len(s.data); i++ {
+		c := s.data[i]
+		switch {
+		case c == '"':
+			val := s.data[start:i]
+			if ascii || utf8.Valid(val) {
+				s.pos = i + 1
+				return val, nil
+			}
+			return s.unescapeString()
+		case c == '\\':
+			return s.unescapeString()
+		case c < 0x20:
+			s.pos = i
+			return nil, s.errorf("invalid character %q in string literal", c)
+		case c >= utf8.RuneSelf:
+			ascii = false
+		}
+	}
+	s.pos = len(s.data)
+	return nil, s.unexpected()
+}
+
+// unescapeString decodes the string at s.pos into the scratch buffer,
+// replacing invalid UTF-8 and surrogates with U+FFFD like encoding/json
+func (s *userScanner) unescapeString() ([]byte, error) {
+	u := s.u
+	start := len(u.scratch)
+	i := s.pos + 1
+	for i < len(s.data) {
+		c := s.data[i]
+		switch {
+		case c == '"':
+			s.pos = i + 1
+			return u.scratch[start:], nil
+		case c < 0x20:
+			s.pos = i
+			return nil, s.errorf("invalid character %q in string literal", c)
+		case c == '\\':
+			if i+1 >= len(s.data) {
+				s.pos = len(s.data)
+				return nil, s.unexpected()
+			}
+			esc := s.data[i+1]
+			switch esc {
+			case '"', '\\', '/':
+				u.scratch = append(u.scratch, esc)
+			case 'b':
+				u.scratch = append(u.scratch, '\b')
+			case 'f':
+				u.scratch = append(u.scratch, '\f')
+			case 'n':
+				u.scratch = append(u.scratch, '\n')
+			case 'r':
+				u.scratch = append(u.scratch, '\r')
+			case 't':
+				u.scratch = append(u.scratch, '\t')
+			case 'u':
+				r, ok := hex4(s.data[i+2:])
+				if !ok {
+					s.pos = i + 2
+					return nil, s.errorf("invalid \\u escape in string literal")
+				}
+				i += 6
+				if utf16.IsSurrogate(r) {
+					r2, ok := rune(0), false
+					if i+1 < len(s.data) && s.data[i] == '\\' && s.data[i+1] == 'u' {
+						r2, ok = hex4(s.data[i+2:])
+					}
+					if dec := utf16.DecodeRune(r, r2); ok && dec != utf8.RuneError {
+						r = dec
+						i += 6
+					} else {
+						r = utf8.RuneError
+					}
+				}
+				u.scratch = utf8.AppendRune(u.scratch, r)
+				continue
+			default:
+				s.pos = i + 1
+				return nil, s.errorf("invalid escape %q in string literal", esc)
+			}
+			i += 2
+		case c < utf8.RuneSelf:
+			u.scratch = append(u.scratch, c)
+			i++
+		default:
+			r, size := utf8.DecodeRune(s.data[i:])
+			u.scratch = utf8.AppendRune(u.scratch, r)
+			i += size
+		}
+	}
+	s.pos = len(s.data)
+	return nil, s.unexpected()
+}
+
+func hex4(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range b[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r*16 + rune(c)
+	}
+	return r, true
+}
+
+// skipValue validates and skips any JSON value nested at depth
+func (s *userScanner) skipValue(depth int) error {
+	if s.pos >= len(s.data) {
+		return s.unexpected()
+	}
+	switch c := s.data[s.pos]; {
+	case c == '{' || c == '[':
+		if depth+1 > maxNestingDepth {
+			return s.errorf("exceeded max depth")
+		}
+		return s.skipContainer(depth + 1)
+	case c == '"':
+		_, err := s.readString()
+		return err
+	case c == '-' || ('0' <= c && c <= '9'):
+		return s.skipNumber()
+	case s.literal("true"), s.literal("false"), s.literal("null"):
+		return nil
+	}
+	return s.unexpected()
+}
+
+func (s *userScanner) skipContainer(depth int) error {
+	isObject := s.data[s.pos] == '{'
+	closing := byte(']')
+	if isObject {
+		closing = '}'
+	}
+	s.pos++
+	s.skipSpace()
+	if s.consume(closing) {
+		return nil
+	}
+	for {
+		if isObject {
+			if s.pos >= len(s.data) || s.data[s.pos] != '"' {
+				return s.unexpected()
+			}
+			if _, err := s.readString(); err != nil {
+				return err
+			}
+			s.skipSpace()
+			if !s.consume(':') {
+				return s.unexpected()
+			}
+			s.skipSpace()
+		}
+		if err := s.skipValue(depth); err != nil {
+			return err
+		}
+		s.skipSpace()
+		if s.consume(closing) {
+			return nil
+		}
+		if !s.consume(',') {
+			return s.unexpected()
+		}
+		s.skipSpace()
+	}
+}
+
+func (s *userScanner) skipDigits() int {
+	start := s.pos
+	for s.pos < len(s.data) && '0' <= s.data[s.pos] && s.data[s.pos] <= '9' {
+		s.pos++
+	}
+	return s.pos - start
+}
+
+func (s *userScanner) skipNumber() error {
+	s.consume('-')
+	if s.consume('0') {
+		// no leading zeros
+	} else if s.skipDigits() == 0 {
+		return s.unexpected()
+	}
+	if s.consume('.') && s.skipDigits() == 0 {
+		return s.unexpected()
+	}
+	if s.consume('e') || s.consume('E') {
+		if !s.consume('+') {
+			s.consume('-')
+		}
+		if s.skipDigits() == 0 {
+			return s.unexpected()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// same fields as userProfile without its UnmarshalJSON
+type plainUserProfile struct {
+	Browsers []string `json:"browsers"`
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+}
+
+var userScanSeeds = []string{
+	`{"browsers":["a","b"],"email":"x@y.z","name":"N","company":"C","job":"J","phone":"1"}`,
+	`{"browsers":[],"email":null,"name":"N"}`,
+	`{"browsers":null}`,
+	`{"browsers":["a",null,"c"]}`,
+	` { "EMAIL" : "e" , "Name":"n", "browſers":["b"] } `,
+	`{"name":"A\n\"\\\/\b\f\r\t😀𐀀x\ud800"}`,
+	`{"name":"\ud800A"}`,
+	"{\"name\":\"\xff\xfe ok \xed\xa0\x80\"}",
+	`{"email":"a","email":"b"}`,
+	`{"extra":{"a":[1,-2.5e+3,true,false,null,{"b":"c"}]},"name":"n"}`,
+	`null`,
+	`{}`,
+	`[]`,
+	`"x"`,
+	`{"email":5}`,
+	`{"browsers":"a"}`,
+	`{"browsers":[1]}`,
+	`{"name":"a"} x`,
+	`{"name":"a`,
+	`{"name":"a\x01"}`,
+	`{"name":"\q"}`,
+	`{"n":01}`,
+	`{"n":1.}`,
+	`{"n":-}`,
+	`{"a":1,}`,
+	``,
+}
+
+func FuzzUserProfileDecode(f *testing.F) {
+	for _, seed := range userScanSeeds {
+		f.Add([]byte(seed))
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, line := range bytes.SplitN(data, []byte("\n"), 20) {
+		f.Add(line)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		expected := plainUserProfile{}
+		expectedErr := json.Unmarshal(data, &expected)
+
+		user := &userProfile{}
+		err := user.decodeLine(data)
+
+		if (err == nil) != (expectedErr == nil) {
+			t.Fatalf("%q: error mismatch, got %v, encoding/json %v", data, err, expectedErr)
+		}
+		if err != nil {
+			return
+		}
+		got := plainUserProfile{Browsers: user.Browsers, Email: user.Email, Name: user.Name}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%q: results not match\nGot: %#v\nExpected: %#v", data, got, expected)
+		}
+	})
+}
+
+func TestUserProfileDecodeReuse(t *testing.T) {
+	user := &userProfile{}
+	if err := user.decodeLine([]byte(`{"browsers":["a","b"],"name":"A"}`)); err != nil {
+		t.Fatal(err)
+	}
+	user.reset()
+	if err := user.decodeLine([]byte(`{"email":"e"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(user.Browsers) != 0 || user.Name != "" || user.Email != "e" {
+		t.Errorf("profile not reset: %#v", user)
+	}
+}
+
+func userLines(tb testing.TB) [][]byte {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	lines := make([][]byte, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+	return lines
+}
+
+func TestUserProfileDecodeAllocs(t *testing.T) {
+	lines := userLines(t)
+	user := &userProfile{}
+	allocs := testing.AllocsPerRun(10, func() {
+		for _, line := range lines {
+			user.reset()
+			if err := user.decodeLine(line); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	// the browsers slice may only grow a few times
+	if allocs > 5 {
+		t.Errorf("decoding allocates: %v allocs for %d lines", allocs, len(lines))
+	}
+	if !strings.Contains(user.Email, "@") {
+		t.Errorf("unexpected email %q", user.Email)
+	}
+}
+
+func BenchmarkDecodeEasyjson(b *testing.B) {
+	lines := userLines(b)
+	user := &userProfile{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, line := range lines {
+			if err := user.UnmarshalJSON(line); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDecodeScanner(b *testing.B) {
+	lines := userLines(b)
+	user := &userProfile{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, line := range lines {
+			user.reset()
+			if err := user.decodeLine(line); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
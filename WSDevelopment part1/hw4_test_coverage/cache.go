@@ -0,0 +1,140 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultCacheEntries = 100
+
+// ResponseCache keeps FindUsers responses by their request parameters. A
+// response is reused without asking the server for TTL, after that it is
+// revalidated with If-None-Match or If-Modified-Since if the server sent
+// ETag or Last-Modified, and requested anew otherwise. Errors are not
+// cached. It is safe for concurrent use, but must not be shared by clients
+// of different servers or tokens.
+type ResponseCache struct {
+	TTL time.Duration
+	// MaxEntries evicts the least recently used responses above it, 100 if
+	// zero
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	key          string
+	resp         SearchResponse
+	etag         string
+	lastModified string
+	expires      time.Time
+}
+
+func (c *ResponseCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// get returns a copy of the entry and whether it is still fresh
+func (c *ResponseCache) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	entry := *elem.Value.(*cacheEntry)
+	return &entry, c.clock().Before(entry.expires)
+}
+
+// put stores the response with the validators of header
+func (c *ResponseCache) put(key string, resp *SearchResponse, header http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries, c.lru = make(map[string]*list.Element), list.New()
+	}
+	entry := &cacheEntry{
+		key:          key,
+		resp:         copyResponse(resp),
+		etag:         header.Get("ETag"),
+		lastModified: header.Get("Last-Modified"),
+		expires:      c.clock().Add(c.TTL),
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	max := c.MaxEntries
+	if max <= 0 {
+		max = defaultCacheEntries
+	}
+	for c.lru.Len() > max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// refresh extends the entry after the server said it is not modified
+func (c *ResponseCache) refresh(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).expires = c.clock().Add(c.TTL)
+	}
+}
+
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// conditional returns the revalidation headers of the entry
+func (e *cacheEntry) conditional() http.Header {
+	header := http.Header{}
+	if e.etag != "" {
+		header.Set("If-None-Match", e.etag)
+	}
+	if e.lastModified != "" {
+		header.Set("If-Modified-Since", e.lastModified)
+	}
+	return header
+}
+
+// copyResponse keeps callers from changing cached users
+func copyResponse(resp *SearchResponse) SearchResponse {
+	users := make([]User, len(resp.Users))
+	for i := range resp.Users {
+		users[i] = resp.Users[i].clone()
+	}
+	return SearchResponse{Users: users, NextPage: resp.NextPage}
+}
+
+// clone copies the values of optional fields too
+func (u User) clone() User {
+	for _, field := range []**string{&u.Guid, &u.Balance, &u.Picture, &u.EyeColor, &u.Company,
+		&u.Email, &u.Phone, &u.Address, &u.Registered, &u.FavoriteFruit} {
+		if *field != nil {
+			value := **field
+			*field = &value
+		}
+	}
+	if u.IsActive != nil {
+		active := *u.IsActive
+		u.IsActive = &active
+	}
+	return u
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingServer records the statuses of the dataset answers, validators
+// are removed if plain is set
+func recordingServer(plain bool) (*httptest.Server, func() []int) {
+	var (
+		mu       sync.Mutex
+		statuses []int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		testSearchServer.ServeHTTP(rec, r)
+		if plain {
+			rec.Header().Del("ETag")
+			rec.Header().Del("Last-Modified")
+		}
+		for name, values := range rec.Header() {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+
+		mu.Lock()
+		statuses = append(statuses, rec.Code)
+		mu.Unlock()
+	}))
+	return ts, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int{}, statuses...)
+	}
+}
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+var cacheRequest = SearchRequest{Limit: 3, OrderField: "Id", OrderBy: 1}
+
+func TestResponseCache(t *testing.T) {
+	ts, statuses := recordingServer(false)
+	defer ts.Close()
+	clock := &fakeClock{now: time.Now()}
+	cache := &ResponseCache{TTL: time.Minute, now: clock.Now}
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL, Options: ClientOptions{Cache: cache}}
+
+	first, err := cl.FindUsers(cacheRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// changes by the caller must not reach the cache
+	expected := copyResponse(first)
+	first.Users[0].Name = "changed"
+
+	for _, step := range []struct {
+		advance  time.Duration
+		statuses []int
+	}{
+		{time.Second, []int{200}},
+		{time.Minute, []int{200, 304}},
+		{30 * time.Second, []int{200, 304}},
+		{time.Minute, []int{200, 304, 304}},
+	} {
+		clock.now = clock.now.Add(step.advance)
+		resp, err := cl.FindUsers(cacheRequest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*resp, expected) {
+			t.Errorf("Expected cached %v, got %v", expected, *resp)
+		}
+		if got := statuses(); !reflect.DeepEqual(got, step.statuses) {
+			t.Errorf("Expected statuses %v, got %v", step.statuses, got)
+		}
+	}
+
+	// other parameters are other entries
+	if _, err := cl.FindUsers(SearchRequest{Limit: 3, Offset: 3, OrderField: "Id", OrderBy: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 2 || len(statuses()) != 4 {
+		t.Errorf("Expected a new entry, got %d entries and %v", cache.Len(), statuses())
+	}
+	// errors are not cached
+	for i := 0; i < 2; i++ {
+		if _, err := cl.FindUsers(SearchRequest{OrderField: "About"}); err == nil {
+			t.Error("Expected error")
+		}
+	}
+	if cache.Len() != 2 || len(statuses()) != 6 {
+		t.Errorf("Error was cached, got %d entries and %v", cache.Len(), statuses())
+	}
+}
+
+func TestResponseCacheOptionalFields(t *testing.T) {
+	ts, statuses := recordingServer(false)
+	defer ts.Close()
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL, Options: ClientOptions{Cache: &ResponseCache{TTL: time.Minute}}}
+	req := SearchRequest{Limit: 1, OrderField: "Id", OrderBy: 1, Fields: []string{"email", "isActive"}}
+
+	first, err := cl.FindUsers(req)
+	if err != nil || len(first.Users) != 1 || first.Users[0].Email == nil || first.Users[0].IsActive == nil {
+		t.Fatalf("Unexpected response %v %v", first, err)
+	}
+	email, active := *first.Users[0].Email, *first.Users[0].IsActive
+	*first.Users[0].Email = "changed"
+	*first.Users[0].IsActive = !active
+
+	resp, err := cl.FindUsers(req)
+	if err != nil || len(statuses()) != 1 {
+		t.Fatalf("Expected a cached response, got %v %v", statuses(), err)
+	}
+	if u := resp.Users[0]; *u.Email != email || *u.IsActive != active {
+		t.Errorf("Optional fields changed in the cache: %s %v", *u.Email, *u.IsActive)
+	}
+}
+
+func TestResponseCacheWithoutValidators(t *testing.T) {
+	ts, statuses := recordingServer(true)
+	defer ts.Close()
+	clock := &fakeClock{now: time.Now()}
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL, Options: ClientOptions{
+		Cache: &ResponseCache{TTL: time.Minute, now: clock.Now},
+	}}
+	for i := 0; i < 3; i++ {
+		if _, err := cl.FindUsers(cacheRequest); err != nil {
+			t.Fatal(err)
+		}
+		clock.now = clock.now.Add(40 * time.Second)
+	}
+	if got := statuses(); !reflect.DeepEqual(got, []int{200, 200}) {
+		t.Errorf("Expected two full requests, got %v", got)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	ts, statuses := recordingServer(false)
+	defer ts.Close()
+	cache := &ResponseCache{TTL: time.Minute, MaxEntries: 2}
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL, Options: ClientOptions{Cache: cache}}
+
+	// 2 is evicted as the least recently used
+	for _, offset := range []int{1, 2, 1, 3, 1, 2} {
+		if _, err := cl.FindUsers(SearchRequest{Limit: 1, Offset: offset}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(statuses()); n != 4 || cache.Len() != 2 {
+		t.Errorf("Expected 4 requests and 2 entries, got %d and %d", n, cache.Len())
+	}
+
+	// a 304 for an evicted entry is a server error
+	ts304 := httptest.NewServer(statusHandler(http.StatusNotModified, ""))
+	defer ts304.Close()
+	cl = &SearchClient{AccessToken: "1234", URL: ts304.URL, Options: ClientOptions{Cache: &ResponseCache{}}}
+	if _, err := cl.FindUsers(cacheRequest); err == nil {
+		t.Error("Expected error")
+	}
+}
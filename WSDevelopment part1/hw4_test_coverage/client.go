@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -28,6 +31,19 @@ type User struct {
 	Age    int
 	About  string
 	Gender string
+
+	// optional fields are nil unless requested with SearchRequest.Fields
+	Guid          *string `json:",omitempty"`
+	IsActive      *bool   `json:",omitempty"`
+	Balance       *string `json:",omitempty"`
+	Picture       *string `json:",omitempty"`
+	EyeColor      *string `json:",omitempty"`
+	Company       *string `json:",omitempty"`
+	Email         *string `json:",omitempty"`
+	Phone         *string `json:",omitempty"`
+	Address       *string `json:",omitempty"`
+	Registered    *string `json:",omitempty"`
+	FavoriteFruit *string `json:",omitempty"`
 }
 
 type SearchResponse struct {
@@ -45,8 +61,87 @@ const (
 	OrderByDesc = 1
 
 	ErrorBadOrderField = `OrderField invalid`
+
+	// OrderFieldRelevance matches the words of Query as prefixes of words
+	// in any case and orders users by relevance, best first with
+	// OrderByDesc
+	OrderFieldRelevance = "relevance"
+)
+
+var (
+	ErrUnauthorized = errors.New("Bad AccessToken")
+	// ErrTokenExpired and ErrInvalidToken are ErrUnauthorized for signed
+	// tokens
+	ErrTokenExpired = fmt.Errorf("%w: token expired", ErrUnauthorized)
+	ErrInvalidToken = fmt.Errorf("%w: invalid token", ErrUnauthorized)
+	// ErrTimeout is wrapped with the request parameters
+	ErrTimeout = errors.New("timeout")
 )
 
+// BadRequestError reports a request rejected by the client or the server.
+type BadRequestError struct {
+	// Field of SearchRequest at fault, empty if the server did not say
+	Field   string
+	Message string
+}
+
+func (e *BadRequestError) Error() string {
+	if e.Field == "" {
+		return "bad request: " + e.Message
+	}
+	return fmt.Sprintf("bad request: %s %s", e.Field, e.Message)
+}
+
+// ServerError reports an unexpected status or a response that could not be
+// decoded.
+type ServerError struct {
+	Status int
+	Err    error
+}
+
+func (e *ServerError) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("SearchServer error (%d): %v", e.Status, e.Err)
+	case e.Status == http.StatusInternalServerError:
+		return "SearchServer fatal error"
+	}
+	return fmt.Sprintf("SearchServer error: %d %s", e.Status, http.StatusText(e.Status))
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
+
+// ForbiddenError reports a request the token does not allow because of a
+// missing scope or a field it cannot see.
+type ForbiddenError struct {
+	Scope string
+	Field string
+}
+
+func (e *ForbiddenError) Error() string {
+	switch {
+	case e.Scope != "":
+		return "forbidden: missing scope " + e.Scope
+	case e.Field != "":
+		return "forbidden: field " + e.Field
+	}
+	return "forbidden"
+}
+
+// errorFields maps SearchErrorResponse errors to SearchRequest fields, the
+// error is either the key or the key, ":" and details
+var errorFields = map[string]string{
+	"ErrorBadOrderField": "OrderField",
+	"BadOrderByValue":    "OrderBy",
+	"BadLimitValue":      "Limit",
+	"BadOffsetValue":     "Offset",
+	"BadFilter":          "Filters",
+	"BadSort":            "Sort",
+	"BadFields":          "Fields",
+}
+
 type SearchRequest struct {
 	Limit      int
 	Offset     int    // Можно учесть после сортировки
@@ -54,28 +149,99 @@ type SearchRequest struct {
 	OrderField string
 	// -1 по убыванию, 0 как встретилось, 1 по возрастанию
 	OrderBy int
+	// Filters must all hold in addition to Query
+	Filters []Filter
+	// Sort replaces OrderField and OrderBy if not empty
+	Sort []SortKey
+	// Fields requests optional User fields by their dataset names: guid,
+	// isActive, balance, picture, eyeColor, company, email, phone, address,
+	// registered, favoriteFruit
+	Fields []string
 }
 
 type SearchClient struct {
 	// токен, по которому происходит авторизация на внешней системе, уходит туда через хедер
 	AccessToken string
+	// Tokens replaces AccessToken if set, it is asked before every attempt
+	Tokens TokenSource
 	// урл внешней системы, куда идти
 	URL string
+	// Endpoints replaces URL with several search servers if set
+	Endpoints *EndpointPool
+	Options   ClientOptions
+}
+
+// ClientOptions tune how SearchClient talks to the search server, the zero
+// value gives a single attempt through the shared client with 1s timeout.
+type ClientOptions struct {
+	// HTTPClient is the shared client if nil
+	HTTPClient *http.Client
+	// Timeout limits every attempt in addition to the client timeout
+	Timeout   time.Duration
+	Retry     RetryPolicy
+	UserAgent string
+	// Cache reuses responses to repeated requests if set
+	Cache *ResponseCache
+}
+
+// RetryPolicy repeats requests that timed out or got a 5xx status, waiting
+// BaseDelay, 2*BaseDelay, ... up to MaxDelay between attempts. Every delay
+// is randomly shortened by up to a half.
+type RetryPolicy struct {
+	// Attempts counts the first request too, 1 if zero
+	Attempts  int
+	BaseDelay time.Duration
+	// MaxDelay is defaultMaxRetryDelay if zero
+	MaxDelay time.Duration
+}
+
+const defaultMaxRetryDelay = 30 * time.Second
+
+func (p RetryPolicy) attempts() int {
+	if p.Attempts < 1 {
+		return 1
+	}
+	return p.Attempts
+}
+
+// backoff returns the delay after the attempt, counted from 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	max := p.MaxDelay
+	if max <= 0 {
+		max = defaultMaxRetryDelay
+	}
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay > 0 && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
+
+// FindUsersContext is FindUsers which stops retrying and waiting for the
+// server once ctx is done.
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
 
 	searcherParams := url.Values{}
 
 	if req.Limit < 0 {
-		return nil, fmt.Errorf("limit must be > 0")
+		return nil, &BadRequestError{Field: "Limit", Message: "must be >= 0"}
 	}
 	if req.Limit > 25 {
 		req.Limit = 25
 	}
 	if req.Offset < 0 {
-		return nil, fmt.Errorf("offset must be > 0")
+		return nil, &BadRequestError{Field: "Offset", Message: "must be >= 0"}
 	}
 
 	//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
@@ -86,41 +252,99 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 	searcherParams.Add("query", req.Query)
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	for _, filter := range req.Filters {
+		searcherParams.Add("filter", filter.String())
+	}
+	if len(req.Sort) > 0 {
+		searcherParams.Add("sort", encodeSort(req.Sort))
+	}
+	if len(req.Fields) > 0 {
+		searcherParams.Add("fields", strings.Join(req.Fields, ","))
+	}
 
-	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
-	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	key := searcherParams.Encode()
+	var header http.Header
+	cache := srv.Options.Cache
+	if cache != nil {
+		if entry, fresh := cache.get(key); fresh {
+			resp := copyResponse(&entry.resp)
+			return &resp, nil
+		} else if entry != nil {
+			header = entry.conditional()
+		}
+	}
 
-	resp, err := client.Do(searcherReq)
+	res, err := srv.do(ctx, searcherParams, header)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
+			return nil, fmt.Errorf("%w for %s", ErrTimeout, searcherParams.Encode())
 		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		return nil, fmt.Errorf("unknown error: %w", err)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
 
-	switch resp.StatusCode {
+	status, body := res.status, res.body
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		// the entry could be evicted meanwhile
+		if cache != nil {
+			if entry, _ := cache.get(key); entry != nil {
+				cache.refresh(key)
+				resp := copyResponse(&entry.resp)
+				return &resp, nil
+			}
+		}
+		return nil, &ServerError{Status: status}
 	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad AccessToken")
-	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
+		// static tokens are rejected without a body
+		errResp := SearchErrorResponse{}
+		json.Unmarshal(body, &errResp)
+		switch errResp.Error {
+		case "TokenExpired":
+			return nil, ErrTokenExpired
+		case "InvalidToken":
+			return nil, ErrInvalidToken
+		}
+		return nil, ErrUnauthorized
+	case http.StatusForbidden:
+		errResp := SearchErrorResponse{}
+		json.Unmarshal(body, &errResp)
+		forbidden := &ForbiddenError{}
+		if i := strings.IndexByte(errResp.Error, ':'); i >= 0 {
+			switch errResp.Error[:i] {
+			case "MissingScope":
+				forbidden.Scope = errResp.Error[i+1:]
+			case "ForbiddenField":
+				forbidden.Field = errResp.Error[i+1:]
+			}
+		}
+		return nil, forbidden
 	case http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
 		err = json.Unmarshal(body, &errResp)
 		if err != nil {
-			return nil, fmt.Errorf("cant unpack error json: %s", err)
+			return nil, &ServerError{Status: status, Err: fmt.Errorf("cant unpack error json: %w", err)}
+		}
+		code, message := errResp.Error, errResp.Error
+		if i := strings.IndexByte(code, ':'); i >= 0 {
+			code, message = code[:i], code[i+1:]
 		}
-		if errResp.Error == "ErrorBadOrderField" {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+		field := errorFields[code]
+		if field == "OrderField" {
+			return nil, &BadRequestError{Field: field, Message: fmt.Sprintf("%q invalid", req.OrderField)}
 		}
-		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
+		return nil, &BadRequestError{Field: field, Message: message}
+	default:
+		return nil, &ServerError{Status: status}
 	}
 
 	data := []User{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return nil, fmt.Errorf("cant unpack result json: %s", err)
+		return nil, &ServerError{Status: status, Err: fmt.Errorf("cant unpack result json: %w", err)}
 	}
 
 	result := SearchResponse{}
@@ -130,6 +354,130 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 	} else {
 		result.Users = data[0:len(data)]
 	}
+	if cache != nil {
+		cache.put(key, &result, res.header)
+	}
 
 	return &result, err
 }
+
+// reply is a response read by doOnce
+type reply struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// do sends the request with the token and the extra header, retrying it
+// according to the retry policy. A token reported expired is invalidated
+// and the request is sent again once, not counting as an attempt.
+func (srv *SearchClient) do(ctx context.Context, params url.Values, header http.Header) (reply, error) {
+	retry := srv.Options.Retry
+	invalidated := false
+	for attempt := 1; ; attempt++ {
+		token := srv.AccessToken
+		if srv.Tokens != nil {
+			var err error
+			if token, err = srv.Tokens.Token(ctx); err != nil {
+				return reply{}, fmt.Errorf("token source: %w", err)
+			}
+		}
+		attemptHeader := header.Clone()
+		if attemptHeader == nil {
+			attemptHeader = http.Header{}
+		}
+		attemptHeader.Set("AccessToken", token)
+
+		res, err := srv.doEndpoints(ctx, params, attemptHeader)
+		if tokens, ok := srv.Tokens.(TokenInvalidator); ok && !invalidated && err == nil && tokenExpired(res) {
+			tokens.InvalidateToken(token)
+			invalidated = true
+			attempt--
+			continue
+		}
+		if attempt >= retry.attempts() || !retriable(res.status, err) || ctx.Err() != nil {
+			return res, err
+		}
+
+		timer := time.NewTimer(retry.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return reply{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func tokenExpired(res reply) bool {
+	if res.status != http.StatusUnauthorized {
+		return false
+	}
+	errResp := SearchErrorResponse{}
+	json.Unmarshal(res.body, &errResp)
+	return errResp.Error == "TokenExpired"
+}
+
+func retriable(status int, err error) bool {
+	if err != nil {
+		netErr, ok := err.(net.Error)
+		return ok && netErr.Timeout()
+	}
+	return status >= http.StatusInternalServerError
+}
+
+// doEndpoints sends the request to URL, or to the endpoints in the pool
+// order until one answers without a 5xx status
+func (srv *SearchClient) doEndpoints(ctx context.Context, params url.Values, header http.Header) (reply, error) {
+	if srv.Endpoints == nil {
+		return srv.doOnce(ctx, srv.URL, params, header)
+	}
+
+	res, err := reply{}, errors.New("no search endpoints")
+	for _, e := range srv.Endpoints.order() {
+		start := time.Now()
+		res, err = srv.doOnce(ctx, e.url, params, header)
+		if ctx.Err() != nil {
+			// not the endpoint fault
+			return res, err
+		}
+		// any transport error sends the request to the next endpoint
+		failed := err != nil || res.status >= http.StatusInternalServerError
+		srv.Endpoints.report(e, time.Since(start), failed)
+		if !failed {
+			return res, err
+		}
+	}
+	return res, err
+}
+
+func (srv *SearchClient) doOnce(ctx context.Context, searcherURL string, params url.Values, header http.Header) (reply, error) {
+	if srv.Options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, srv.Options.Timeout)
+		defer cancel()
+	}
+
+	searcherReq, err := http.NewRequestWithContext(ctx, http.MethodGet, searcherURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return reply{}, err
+	}
+	for name, values := range header {
+		searcherReq.Header[name] = values
+	}
+	if srv.Options.UserAgent != "" {
+		searcherReq.Header.Set("User-Agent", srv.Options.UserAgent)
+	}
+
+	httpClient := srv.Options.HTTPClient
+	if httpClient == nil {
+		httpClient = client
+	}
+	resp, err := httpClient.Do(searcherReq)
+	if err != nil {
+		return reply{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return reply{status: resp.StatusCode, header: resp.Header, body: body}, err
+}
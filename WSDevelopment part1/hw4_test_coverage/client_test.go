@@ -1,240 +1,30 @@
 package main
 
 import (
-	"encoding/json"
-	"encoding/xml"
-	"fmt"
-	"io/ioutil"
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"reflect"
-	"sort"
-	"strconv"
-	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
-)
-
-type Users struct {
-	XMLName xml.Name  `xml:"root"`
-	Users   []RowUser `xml:"row"`
-}
-
-type RowUser struct {
-	ID             int    `xml:"id"`
-	Guid           string `xml:"guid"`
-	IsActive       bool   `xml:"isActive"`
-	Balance        string `xml:"balance"`
-	Picture        string `xml:"picture"`
-	Age            string `xml:"age"`
-	EyeColor       string `xml:"eyeColor"`
-	FirstName      string `xml:"first_name"`
-	LastName       string `xml:"last_name"`
-	Gender         string `xml:"gender"`
-	Company        string `xml:"company"`
-	Email          string `xml:"email"`
-	Phone          string `xml:"phone"`
-	Address        string `xml:"address"`
-	About          string `xml:"about"`
-	Registered     string `xml:"registered"`
-	FavouriteFruit string `xml:"favoriteFruit"`
-}
-
-func (ru *RowUser) SelectQuery(query string) bool {
-	return strings.Contains(ru.About, query) || strings.Contains(ru.FirstName, query) || strings.Contains(ru.LastName, query)
-}
-
-func rowToUser(ru RowUser) User {
-
-	u := User{}
-	u.Id = ru.ID
-	u.Name = ru.FirstName + " " + ru.LastName
-	age, err := strconv.Atoi(ru.Age)
-	if err != nil {
-		fmt.Println("Cant parse age: age will be set 0")
-		u.Age = 0
-	} else {
-		u.Age = age
-	}
-	u.About = ru.About
-	u.Gender = ru.Gender
-	return u
-}
-
-func selectUsers(users Users, query string) []User {
-
-	usersToAnswer := make([]User, 0)
-
-	if query == "" {
-		for _, rowUser := range users.Users {
-			usersToAnswer = append(usersToAnswer, rowToUser(rowUser))
-		}
-	} else {
-		for _, rowUser := range users.Users {
-
-			if rowUser.SelectQuery(query) {
-				usersToAnswer = append(usersToAnswer, rowToUser(rowUser))
-			}
-		}
-	}
 
-	return usersToAnswer
-
-}
-
-func sortUsers(users []User, orderField string, orderByVal string) ([]User, error) {
-
-	switch orderField {
-	case "Id":
-		switch orderByVal {
-		case "-1":
-			sort.SliceStable(users, func(i, j int) bool {
-				return users[i].Id > users[j].Id
-			})
-		case "0":
-		case "1":
-			sort.SliceStable(users, func(i, j int) bool {
-				return users[i].Id < users[j].Id
-			})
-		default:
-			return nil, fmt.Errorf("BadOrderByValue")
-		}
-	case "Age":
-		switch orderByVal {
-		case "-1":
-			sort.SliceStable(users, func(i, j int) bool {
-				return users[i].Age > users[j].Age
-			})
-		case "0":
-		case "1":
-			sort.SliceStable(users, func(i, j int) bool {
-				return users[i].Age < users[j].Age
-			})
-		default:
-			return nil, fmt.Errorf("BadOrderByValue")
-		}
-	case "Name":
-		switch orderByVal {
-		case "-1":
-			sort.SliceStable(users, func(i, j int) bool {
-				return users[i].Name > users[j].Name
-			})
-		case "0":
-		case "1":
-			sort.SliceStable(users, func(i, j int) bool {
-				return users[i].Name < users[j].Name
-			})
-		default:
-			return nil, fmt.Errorf("BadOrderByValue")
-		}
-	case "":
-		switch orderByVal {
-		case "-1":
-			sort.SliceStable(users, func(i, j int) bool {
-				return users[i].Name > users[j].Name
-			})
-		case "0":
-		case "1":
-			sort.SliceStable(users, func(i, j int) bool {
-				return users[i].Name < users[j].Name
-			})
-		default:
-			return nil, fmt.Errorf("BadOrderByValue")
-		}
-
-	default:
-		return nil, fmt.Errorf(ErrorBadOrderField)
-	}
-	return users, nil
-}
+	"hw4_test_coverage/server"
+)
 
 var filePath = "dataset.xml"
 
-func SearchServer(w http.ResponseWriter, r *http.Request) {
-
-	urlParams := r.URL.Query()
-
-	if r.Header.Get("AccessToken") != "1234" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
-	query := urlParams.Get("query")
-	orderField := urlParams.Get("order_field")
-	orderByValue := urlParams.Get("order_by")
-
-	offset, err := strconv.Atoi(urlParams.Get("offset"))
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	xmlFile, err := os.Open(filePath)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	defer func(){
-		err := xmlFile.Close()
-		if err != nil {
-			panic(err)
-		}
-	}()
-
-	byteValue, _ := ioutil.ReadAll(xmlFile)
-
-	var users Users
-
-	err = xml.Unmarshal(byteValue, &users)
+var testUsers = func() server.Users {
+	users, err := server.LoadUsers(filePath)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	usersToAnswer := selectUsers(users, query)
-
-	ans, err := sortUsers(usersToAnswer, orderField, orderByValue)
-
-	var errAns *SearchErrorResponse
-	if err != nil {
-		switch err.Error() {
-		case "BadOrderByValue":
-			errAns = &SearchErrorResponse{Error: "BadOrderByValue"}
-		case ErrorBadOrderField:
-			errAns = &SearchErrorResponse{Error: "ErrorBadOrderField"}
-		}
-		ansJson, err := json.Marshal(errAns)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		_, err = w.Write(ansJson)
-		if err != nil {
-			panic(err)
-		}
-		return
-	}
-
-	var ansLen int
-	if len(ans) < offset {
-		ansLen = len(ans)
-	} else {
-		ansLen = offset
-	}
-	byteAns, err := json.Marshal(ans[:ansLen])
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		panic(err)
 	}
+	return users
+}()
 
-	_, err = w.Write(byteAns)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-}
+// testSearchServer serves dataset.xml to the "1234" token
+var testSearchServer = server.NewSearchServer(testUsers, []string{"1234"})
 
 type TestCase struct {
 	ID      int
@@ -260,7 +50,7 @@ func TestFindUsers(t *testing.T) {
 				NextPage: false,
 			},
 			Request: &SearchRequest{Limit: 25,
-				Offset:     1,
+				Offset:     0,
 				Query:      "Rebekah",
 				OrderField: "Name",
 				OrderBy:    1,
@@ -269,7 +59,7 @@ func TestFindUsers(t *testing.T) {
 		},
 	}
 
-	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	ts := httptest.NewServer(testSearchServer)
 	defer ts.Close()
 
 	for caseNum, item := range cases {
@@ -329,7 +119,7 @@ func TestRequestLimitOffset(t *testing.T) {
 		},
 	}
 
-	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	ts := httptest.NewServer(testSearchServer)
 	defer ts.Close()
 
 	for _, item := range cases {
@@ -356,7 +146,7 @@ func TestRequestLimitOffset(t *testing.T) {
 
 func TestLargeLimit(t *testing.T) {
 
-	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	ts := httptest.NewServer(testSearchServer)
 	defer ts.Close()
 
 	cl := &SearchClient{AccessToken: "1234", URL: ts.URL}
@@ -387,20 +177,13 @@ func TestLargeLimit(t *testing.T) {
 
 func TestLargeAnswer(t *testing.T) {
 
-	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	ts := httptest.NewServer(testSearchServer)
 	defer ts.Close()
 
 	cl := &SearchClient{AccessToken: "1234", URL: ts.URL}
 
-	RequestLarge := &SearchRequest{Limit: 1,
-		Offset:     2,
-		Query:      "c",
-		OrderField: "Name",
-		OrderBy:    1,
-	}
-
-	RequestSmall := &SearchRequest{Limit: 1,
-		Offset:     1,
+	RequestLarge := &SearchRequest{Limit: 2,
+		Offset:     0,
 		Query:      "c",
 		OrderField: "Name",
 		OrderBy:    1,
@@ -408,10 +191,23 @@ func TestLargeAnswer(t *testing.T) {
 
 	respLarge, _ := cl.FindUsers(*RequestLarge)
 
-	respSmall, _ := cl.FindUsers(*RequestSmall)
+	pages := []User{}
+	for offset := 0; offset < 2; offset++ {
+		RequestSmall := &SearchRequest{Limit: 1,
+			Offset:     offset,
+			Query:      "c",
+			OrderField: "Name",
+			OrderBy:    1,
+		}
+		respSmall, _ := cl.FindUsers(*RequestSmall)
+		if !respSmall.NextPage {
+			t.Errorf("[%v] Expected next page", offset)
+		}
+		pages = append(pages, respSmall.Users...)
+	}
 
-	if !reflect.DeepEqual(respLarge.Users, respSmall.Users) {
-		t.Errorf("Not equal answers when large limit set")
+	if !respLarge.NextPage || !reflect.DeepEqual(respLarge.Users, pages) {
+		t.Errorf("Not equal answers when paging by one user")
 	}
 
 }
@@ -431,7 +227,7 @@ func TestBadAuth(t *testing.T) {
 		},
 	}
 
-	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	ts := httptest.NewServer(testSearchServer)
 	defer ts.Close()
 
 	for caseNum, item := range cases {
@@ -469,7 +265,7 @@ func TestBadRequest(t *testing.T) {
 		},
 	}
 
-	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	ts := httptest.NewServer(testSearchServer)
 	defer ts.Close()
 
 	for caseNum, item := range cases {
@@ -704,3 +500,273 @@ func TestBrokenErrorJson(t *testing.T) {
 
 	}
 }
+
+// flakyServer fails the first requests with status, or by sleeping if
+// status is 0, and then serves the dataset
+func flakyServer(failures int32, status int) (*httptest.Server, *int32) {
+	calls := new(int32)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(calls, 1) > failures {
+			testSearchServer.ServeHTTP(w, r)
+			return
+		}
+		if status == 0 {
+			time.Sleep(100 * time.Millisecond)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	return ts, calls
+}
+
+var retryRequest = SearchRequest{Limit: 1, Query: "Rebekah", OrderBy: 1}
+
+func TestRetry(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusBadGateway, 0} {
+		ts, calls := flakyServer(2, status)
+		cl := &SearchClient{AccessToken: "1234", URL: ts.URL, Options: ClientOptions{
+			Timeout: 20 * time.Millisecond,
+			Retry:   RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond},
+		}}
+
+		resp, err := cl.FindUsersContext(context.Background(), retryRequest)
+		if err != nil || len(resp.Users) != 1 || resp.Users[0].Id != 27 {
+			t.Errorf("[%v] Unexpected result %v %v", status, resp, err)
+		}
+		if *calls != 3 {
+			t.Errorf("[%v] Expected 3 calls, got %d", status, *calls)
+		}
+
+		atomic.StoreInt32(calls, 0)
+		cl.Options.Retry.Attempts = 2
+		if _, err := cl.FindUsers(retryRequest); err == nil {
+			t.Errorf("[%v] Expected error after 2 attempts", status)
+		}
+		ts.Close()
+	}
+}
+
+func TestRetryNotRetriable(t *testing.T) {
+	ts, calls := flakyServer(5, http.StatusBadRequest)
+	defer ts.Close()
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL, Options: ClientOptions{Retry: RetryPolicy{Attempts: 5}}}
+	if _, err := cl.FindUsers(retryRequest); err == nil || *calls != 1 {
+		t.Errorf("Expected a single failed call, got %d calls, error %v", *calls, err)
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	ts, calls := flakyServer(5, http.StatusServiceUnavailable)
+	defer ts.Close()
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL, Options: ClientOptions{
+		Retry: RetryPolicy{Attempts: 5, BaseDelay: time.Hour},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := cl.FindUsersContext(ctx, retryRequest); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if *calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("Backoff not interrupted: %d calls in %v", *calls, time.Since(start))
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cl.FindUsersContext(canceled, retryRequest); err != context.Canceled {
+		t.Errorf("Expected canceled, got %v", err)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	var userAgent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		testSearchServer.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	transport := &countingTransport{}
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL, Options: ClientOptions{
+		HTTPClient: &http.Client{Transport: transport},
+		UserAgent:  "dashboard/1.0",
+	}}
+	if _, err := cl.FindUsers(retryRequest); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != "dashboard/1.0" || transport.requests != 1 {
+		t.Errorf("Options not used: user agent %q, %d requests", userAgent, transport.requests)
+	}
+
+	cl.URL = "http://[::1"
+	if _, err := cl.FindUsers(retryRequest); err == nil {
+		t.Errorf("Expected error for bad url")
+	}
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.requests++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	for attempt, max := range map[int]time.Duration{1: 100, 2: 200, 3: 300, 10: 300, 100: 300} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(attempt); d < max/2 || d > max {
+				t.Errorf("[%v] Delay %v out of [%v, %v]", attempt, d, max/2, max)
+			}
+		}
+	}
+	if d := (RetryPolicy{}).backoff(3); d != 0 {
+		t.Errorf("Expected no delay, got %v", d)
+	}
+	if d := (RetryPolicy{BaseDelay: time.Millisecond}).backoff(4); d < 4*time.Millisecond || d > 8*time.Millisecond {
+		t.Errorf("Unexpected unbounded delay %v", d)
+	}
+	for _, attempt := range []int{40, 100} {
+		if d := (RetryPolicy{BaseDelay: time.Millisecond}).backoff(attempt); d < defaultMaxRetryDelay/2 || d > defaultMaxRetryDelay {
+			t.Errorf("[%v] Delay %v not capped by the default", attempt, d)
+		}
+	}
+}
+
+func statusHandler(status int, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	})
+}
+
+func TestErrorTypes(t *testing.T) {
+	valid := SearchRequest{Limit: 1, OrderField: "Name", OrderBy: 1}
+	type check func(err error) bool
+	isBadRequest := func(field string) check {
+		return func(err error) bool {
+			var badReq *BadRequestError
+			return errors.As(err, &badReq) && badReq.Field == field
+		}
+	}
+	isServerError := func(status int, decoding bool) check {
+		return func(err error) bool {
+			var srvErr *ServerError
+			return errors.As(err, &srvErr) && srvErr.Status == status && (srvErr.Err != nil) == decoding
+		}
+	}
+
+	cases := []struct {
+		name    string
+		handler http.Handler
+		req     SearchRequest
+		check   check
+	}{
+		{"limit", testSearchServer, SearchRequest{Limit: -1}, isBadRequest("Limit")},
+		{"offset", testSearchServer, SearchRequest{Offset: -1}, isBadRequest("Offset")},
+		{"order field", testSearchServer, SearchRequest{OrderField: "About"}, isBadRequest("OrderField")},
+		{"order by", testSearchServer, SearchRequest{OrderBy: 5}, isBadRequest("OrderBy")},
+		{"unknown bad request", statusHandler(http.StatusBadRequest, `{"Error":"Whatever"}`), valid, isBadRequest("")},
+		{"broken error json", http.HandlerFunc(ReturnErrorBrokenJson), valid, isServerError(http.StatusBadRequest, true)},
+		{"broken json", http.HandlerFunc(ReturnBrokenJson), valid, isServerError(http.StatusOK, true)},
+		{"internal", http.HandlerFunc(InternalServerSimulate), valid, isServerError(http.StatusInternalServerError, false)},
+		{"unavailable", statusHandler(http.StatusServiceUnavailable, ""), valid, isServerError(http.StatusServiceUnavailable, false)},
+		{"not found", http.NotFoundHandler(), valid, isServerError(http.StatusNotFound, false)},
+		{"unauthorized", statusHandler(http.StatusUnauthorized, ""), valid, func(err error) bool { return errors.Is(err, ErrUnauthorized) }},
+	}
+
+	for _, c := range cases {
+		ts := httptest.NewServer(c.handler)
+		cl := &SearchClient{AccessToken: "1234", URL: ts.URL}
+		resp, err := cl.FindUsers(c.req)
+		if resp != nil || !c.check(err) {
+			t.Errorf("[%s] Unexpected error %#v (%v)", c.name, err, err)
+		}
+		ts.Close()
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer ts.Close()
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL, Options: ClientOptions{Timeout: 10 * time.Millisecond}}
+	if _, err := cl.FindUsers(valid); !errors.Is(err, ErrTimeout) {
+		t.Errorf("[timeout] Unexpected error %v", err)
+	}
+
+	cl = &SearchClient{AccessToken: "1234", URL: "unknown://host"}
+	if _, err := cl.FindUsers(valid); err == nil || errors.Is(err, ErrTimeout) || errors.Unwrap(err) == nil {
+		t.Errorf("[transport] Unexpected error %v", err)
+	}
+}
+
+func TestErrorMessages(t *testing.T) {
+	for err, expected := range map[error]string{
+		&BadRequestError{Field: "Limit", Message: "must be >= 0"}: "bad request: Limit must be >= 0",
+		&BadRequestError{Message: "Whatever"}:                     "bad request: Whatever",
+		&ServerError{Status: http.StatusInternalServerError}:      "SearchServer fatal error",
+		&ServerError{Status: http.StatusBadGateway}:               "SearchServer error: 502 Bad Gateway",
+		&ServerError{Status: http.StatusOK, Err: errTest}:         "SearchServer error (200): testing",
+	} {
+		if err.Error() != expected {
+			t.Errorf("Expected %q, got %q", expected, err.Error())
+		}
+	}
+}
+
+func TestFindUsersFields(t *testing.T) {
+	ts := httptest.NewServer(testSearchServer)
+	defer ts.Close()
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL}
+
+	resp, err := cl.FindUsers(SearchRequest{Limit: 1, OrderField: "Id", OrderBy: 1, Fields: []string{"email", " company", "isActive", ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	email, company, active := "boydwolf@hopeli.com", "HOPELI", false
+	expected := User{Id: 0, Name: "Boyd Wolf", Age: 22, Gender: "male", Email: &email, Company: &company, IsActive: &active}
+	user := resp.Users[0]
+	user.About = ""
+	if !reflect.DeepEqual(user, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, user)
+	}
+
+	all := []string{"guid", "isActive", "balance", "picture", "eyeColor", "company", "email", "phone", "address", "registered", "favoriteFruit"}
+	resp, err = cl.FindUsers(SearchRequest{Limit: 1, Offset: 1, OrderField: "Id", OrderBy: 1, Fields: all})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user = resp.Users[0]
+	if user.Guid == nil || user.Balance == nil || user.Picture == nil || user.EyeColor == nil || user.Phone == nil ||
+		user.Address == nil || *user.Registered != "2016-11-20T04:40:07 -03:00" || *user.FavoriteFruit != "banana" {
+		t.Errorf("Expected all fields, got %+v", user)
+	}
+
+	// optional fields are nil unless requested
+	resp, err = cl.FindUsers(SearchRequest{Limit: 1, OrderField: "Id", OrderBy: 1})
+	if err != nil || resp.Users[0].Email != nil || resp.Users[0].IsActive != nil {
+		t.Errorf("Unexpected optional fields %+v %v", resp, err)
+	}
+
+	var badReq *BadRequestError
+	if _, err := cl.FindUsers(SearchRequest{Fields: []string{"email", "password"}}); !errors.As(err, &badReq) || badReq.Field != "Fields" {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	srv := server.NewSearchServer(testUsers, nil)
+	srv.TokenKey = tokenKey
+	tokenTS := httptest.NewServer(srv)
+	defer tokenTS.Close()
+	cl = &SearchClient{URL: tokenTS.URL, Tokens: &SignedTokenSource{Key: tokenKey, Claims: server.TokenClaims{Scope: server.ScopeSearch, Fields: []string{"Name", "Email"}}}}
+	if _, err := cl.FindUsers(SearchRequest{Fields: []string{"email"}}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	var forbidden *ForbiddenError
+	if _, err := cl.FindUsers(SearchRequest{Fields: []string{"email", "phone"}}); !errors.As(err, &forbidden) || forbidden.Field != "Phone" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Searchserver serves a dataset of users to SearchClient.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"hw4_test_coverage/server"
+)
+
+func main() {
+	addr, srv, err := newServer(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	httpServer := &http.Server{Addr: addr, Handler: srv}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving %d users on %s", srv.Len(), addr)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// newServer loads the dataset and tokens given on the command line
+func newServer(args []string, stderr io.Writer) (string, *server.SearchServer, error) {
+	flags := flag.NewFlagSet("searchserver", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	addr := flags.String("addr", ":8080", "listen `address`")
+	data := flags.String("data", "dataset.xml", "users `file`: .xml, .json or .csv")
+	tokens := flags.String("tokens", "", "comma separated access `tokens`")
+	tokensFile := flags.String("tokens-file", "", "`file` with an access token per line")
+	keyFile := flags.String("token-key-file", "", "`file` with the key of signed tokens")
+	if err := flags.Parse(args); err != nil {
+		return "", nil, err
+	}
+
+	list := splitTokens(*tokens, ",")
+	if *tokensFile != "" {
+		content, err := ioutil.ReadFile(*tokensFile)
+		if err != nil {
+			return "", nil, err
+		}
+		list = append(list, splitTokens(string(content), "\n")...)
+	}
+	var key []byte
+	if *keyFile != "" {
+		content, err := ioutil.ReadFile(*keyFile)
+		if err != nil {
+			return "", nil, err
+		}
+		if key = []byte(strings.TrimSpace(string(content))); len(key) == 0 {
+			return "", nil, fmt.Errorf("%s: empty token key", *keyFile)
+		}
+	}
+	if len(list) == 0 && key == nil {
+		return "", nil, fmt.Errorf("no access tokens, use -tokens, -tokens-file or -token-key-file")
+	}
+
+	users, err := server.LoadUsers(*data)
+	if err != nil {
+		return "", nil, err
+	}
+	srv := server.NewSearchServer(users, list)
+	srv.TokenKey = key
+	return *addr, srv, nil
+}
+
+func splitTokens(s, sep string) []string {
+	tokens := make([]string, 0)
+	for _, token := range strings.Split(s, sep) {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
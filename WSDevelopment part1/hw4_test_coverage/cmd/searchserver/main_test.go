@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hw4_test_coverage/server"
+)
+
+const dataPath = "../../dataset.xml"
+
+func request(srv http.Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/?limit=1&offset=0&order_by=0", nil)
+	req.Header.Set("AccessToken", token)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	return w
+}
+
+func TestNewServer(t *testing.T) {
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+	ioutil.WriteFile(tokensFile, []byte("b\n\n c \n"), 0644)
+
+	addr, srv, err := newServer([]string{"-data", dataPath, "-addr", ":9000", "-tokens", "a, ", "-tokens-file", tokensFile}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != ":9000" || srv.Len() != 35 {
+		t.Errorf("unexpected server %s with %d users", addr, srv.Len())
+	}
+	for token, status := range map[string]int{"a": http.StatusOK, "b": http.StatusOK, "c": http.StatusOK, "": http.StatusUnauthorized} {
+		if w := request(srv, token); w.Code != status {
+			t.Errorf("%q: expected status %d, got %d", token, status, w.Code)
+		}
+	}
+
+	keyFile, emptyFile := filepath.Join(t.TempDir(), "key"), filepath.Join(t.TempDir(), "empty")
+	ioutil.WriteFile(keyFile, []byte("secret\n"), 0644)
+	ioutil.WriteFile(emptyFile, []byte("\n"), 0644)
+	_, srv, err = newServer([]string{"-data", dataPath, "-token-key-file", keyFile}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := server.SignToken([]byte("secret"), server.TokenClaims{Scope: server.ScopeSearch, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if string(srv.TokenKey) != "secret" || request(srv, token).Code != http.StatusOK || request(srv, "a").Code != http.StatusUnauthorized {
+		t.Errorf("unexpected server %q", srv.TokenKey)
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"-tokens", "a", "-data", "missing.xml"},
+		{"-tokens-file", "missing"},
+		{"-token-key-file", "missing"},
+		{"-token-key-file", emptyFile},
+		{"-unknown"},
+	} {
+		if _, _, err := newServer(args, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
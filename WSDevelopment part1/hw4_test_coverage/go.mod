@@ -0,0 +1,3 @@
+module hw4_test_coverage
+
+go 1.16
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"hw4_test_coverage/server"
+)
+
+func TestFindUsersRelevance(t *testing.T) {
+	ts := httptest.NewServer(testSearchServer)
+	defer ts.Close()
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL}
+
+	find := func(orderBy int) []int {
+		ids := []int{}
+		it := cl.Iter(context.Background(), SearchRequest{Query: "NULLA ex", OrderField: OrderFieldRelevance, OrderBy: orderBy})
+		for it.Next() {
+			ids = append(ids, it.User().Id)
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+
+	best, worst, asIs := find(OrderByDesc), find(OrderByAsc), find(OrderByAsIs)
+	if len(best) == 0 || len(best) != len(worst) || len(best) != len(asIs) {
+		t.Fatalf("Unexpected results %v %v %v", best, worst, asIs)
+	}
+	// the server ranks the same users, as is they keep the dataset order
+	sortedBest, sortedWorst := append([]int(nil), best...), append([]int(nil), worst...)
+	sort.Ints(sortedBest)
+	sort.Ints(sortedWorst)
+	if !sort.IntsAreSorted(asIs) || !reflect.DeepEqual(sortedBest, asIs) || !reflect.DeepEqual(sortedWorst, asIs) {
+		t.Fatalf("Unexpected order %v %v %v", best, worst, asIs)
+	}
+
+	var badReq *BadRequestError
+	if _, err := cl.FindUsers(SearchRequest{OrderField: OrderFieldRelevance, OrderBy: 2}); !errors.As(err, &badReq) || badReq.Field != "OrderBy" {
+		t.Errorf("Unexpected error %v", err)
+	}
+	// sort replaces the relevance order
+	resp, err := cl.FindUsers(SearchRequest{Limit: 3, OrderField: OrderFieldRelevance, Sort: []SortKey{{Field: "Id", Desc: true}}})
+	if err != nil || resp.Users[0].Id != 34 {
+		t.Errorf("Unexpected result %v %v", resp, err)
+	}
+
+	// the relevance order needs no field of a limited token
+	srv := server.NewSearchServer(testUsers, nil)
+	srv.TokenKey = tokenKey
+	tokenTS := httptest.NewServer(srv)
+	defer tokenTS.Close()
+	cl = &SearchClient{URL: tokenTS.URL, Tokens: &SignedTokenSource{Key: tokenKey, Claims: server.TokenClaims{Scope: server.ScopeSearch, Fields: []string{"Name"}}}}
+	if _, err := cl.FindUsers(SearchRequest{OrderField: OrderFieldRelevance, OrderBy: OrderByDesc}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import "context"
+
+// maxPageSize is the most users FindUsers returns at once
+const maxPageSize = 25
+
+// UserIterator pages through all users matching a request:
+//
+//	it := client.Iter(ctx, req)
+//	for it.Next() {
+//		use(it.User())
+//	}
+//	if err := it.Err(); err != nil {
+//
+// Limit of the request is the page size, 25 if zero.
+type UserIterator struct {
+	// Prefetch requests the next page while the current one is read, it
+	// must be set before the first Next
+	Prefetch bool
+
+	client *SearchClient
+	ctx    context.Context
+	req    SearchRequest
+	users  []User
+	user   User
+	err    error
+	last   bool
+	next   chan pageResult
+}
+
+type pageResult struct {
+	resp *SearchResponse
+	err  error
+}
+
+func (srv *SearchClient) Iter(ctx context.Context, req SearchRequest) *UserIterator {
+	if req.Limit <= 0 || req.Limit > maxPageSize {
+		req.Limit = maxPageSize
+	}
+	return &UserIterator{client: srv, ctx: ctx, req: req}
+}
+
+// Next advances to the next user, it returns false when there are no more
+// users or a request failed.
+func (it *UserIterator) Next() bool {
+	for len(it.users) == 0 {
+		if it.last || it.err != nil {
+			return false
+		}
+		it.fetch()
+	}
+	it.user, it.users = it.users[0], it.users[1:]
+	return true
+}
+
+func (it *UserIterator) User() User {
+	return it.user
+}
+
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+// fetch gets the page at it.req.Offset, prefetched or not
+func (it *UserIterator) fetch() {
+	var res pageResult
+	if it.next != nil {
+		res = <-it.next
+		it.next = nil
+	} else {
+		res.resp, res.err = it.client.FindUsersContext(it.ctx, it.req)
+	}
+	if res.err != nil {
+		it.err = res.err
+		return
+	}
+
+	it.users = res.resp.Users
+	it.req.Offset += len(it.users)
+	// an empty page with NextPage would never end
+	it.last = !res.resp.NextPage || len(it.users) == 0
+	if !it.last && it.Prefetch {
+		next, req := make(chan pageResult, 1), it.req
+		go func() {
+			resp, err := it.client.FindUsersContext(it.ctx, req)
+			next <- pageResult{resp, err}
+		}()
+		it.next = next
+	}
+}
+
+// IterChan sends the users of Iter with prefetching to the first channel,
+// which is closed when they end. The iteration error or nil is then sent
+// to the second channel. Cancel ctx to stop early.
+func (srv *SearchClient) IterChan(ctx context.Context, req SearchRequest) (<-chan User, <-chan error) {
+	users, errc := make(chan User), make(chan error, 1)
+	it := srv.Iter(ctx, req)
+	it.Prefetch = true
+	go func() {
+		defer close(errc)
+		defer close(users)
+		for it.Next() {
+			select {
+			case users <- it.User():
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+		errc <- it.Err()
+	}()
+	return users, errc
+}
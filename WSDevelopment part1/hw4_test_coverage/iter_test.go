@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// countingServer serves the dataset and fails every request after failAfter
+// with 500 if failAfter > 0
+func countingServer(failAfter int32) (*httptest.Server, *int32) {
+	calls := new(int32)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := atomic.AddInt32(calls, 1); failAfter > 0 && n > failAfter {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		testSearchServer.ServeHTTP(w, r)
+	})), calls
+}
+
+func TestIter(t *testing.T) {
+	for _, c := range []struct {
+		limit, offset, requests int
+		prefetch                bool
+	}{
+		{4, 0, 9, false},
+		{5, 0, 7, true},
+		{0, 0, 2, false},
+		{100, 10, 1, true},
+		{3, 40, 1, true},
+	} {
+		ts, calls := countingServer(0)
+		cl := &SearchClient{AccessToken: "1234", URL: ts.URL}
+		it := cl.Iter(context.Background(), SearchRequest{Limit: c.limit, Offset: c.offset, OrderField: "Id", OrderBy: 1})
+		it.Prefetch = c.prefetch
+
+		expected := c.offset
+		for it.Next() {
+			if it.User().Id != expected {
+				t.Errorf("[%v] Expected user %d, got %d", c, expected, it.User().Id)
+			}
+			expected++
+		}
+		if it.Err() != nil || (expected != 35 && c.offset < 35) || it.Next() {
+			t.Errorf("[%v] Iteration stopped at %d: %v", c, expected, it.Err())
+		}
+		if int(*calls) != c.requests {
+			t.Errorf("[%v] Expected %d requests, got %d", c, c.requests, *calls)
+		}
+		ts.Close()
+	}
+}
+
+func TestIterError(t *testing.T) {
+	for _, prefetch := range []bool{false, true} {
+		ts, _ := countingServer(2)
+		cl := &SearchClient{AccessToken: "1234", URL: ts.URL}
+		it := cl.Iter(context.Background(), SearchRequest{Limit: 10, OrderBy: 1, OrderField: "Id"})
+		it.Prefetch = prefetch
+
+		count := 0
+		for it.Next() {
+			count++
+		}
+		var srvErr *ServerError
+		if count != 20 || !errors.As(it.Err(), &srvErr) {
+			t.Errorf("[%v] Expected 20 users and a server error, got %d %v", prefetch, count, it.Err())
+		}
+		ts.Close()
+	}
+}
+
+func TestIterChan(t *testing.T) {
+	ts, _ := countingServer(0)
+	defer ts.Close()
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL}
+
+	users, errc := cl.IterChan(context.Background(), SearchRequest{Limit: 7, Query: "a", OrderBy: 1})
+	found := 0
+	for range users {
+		found++
+	}
+	if err := <-errc; err != nil || found != countUsers("a") {
+		t.Errorf("Unexpected result: %d users, error %v", found, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	users, errc = cl.IterChan(ctx, SearchRequest{Limit: 2})
+	<-users
+	cancel()
+	for range users {
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Expected canceled, got %v", err)
+	}
+}
+
+// countUsers counts the dataset users matching query
+func countUsers(query string) int {
+	n := 0
+	for _, u := range testUsers.Users {
+		if u.SelectQuery(query) {
+			n++
+		}
+	}
+	return n
+}
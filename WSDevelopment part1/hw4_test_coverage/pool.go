@@ -0,0 +1,164 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Balance selects the endpoint a request goes to first.
+type Balance int
+
+const (
+	RoundRobin Balance = iota
+	// LeastLatency prefers the endpoint with the smallest average latency,
+	// endpoints without successful requests are tried first
+	LeastLatency
+)
+
+const (
+	defaultFailThreshold = 3
+	defaultProbeInterval = 10 * time.Second
+)
+
+// EndpointPool spreads the requests of SearchClient over search servers
+// with the same data. An endpoint is marked down after FailThreshold
+// consecutive timeouts or 5xx statuses, it gets a single probe request
+// every ProbeInterval until one succeeds. A request that fails this way is
+// sent to the next endpoint before the client retry policy applies. When
+// every endpoint is down all of them are tried anyway.
+type EndpointPool struct {
+	URLs    []string
+	Balance Balance
+	// FailThreshold is 3 if zero
+	FailThreshold int
+	// ProbeInterval is 10s if zero
+	ProbeInterval time.Duration
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+	now       func() time.Time
+}
+
+type endpoint struct {
+	url      string
+	failures int
+	down     bool
+	// nextProbe is when a down endpoint may get a request
+	nextProbe time.Time
+	// latency averages successful requests
+	latency time.Duration
+	samples int
+}
+
+// EndpointStatus is the health of an endpoint as seen by the pool.
+type EndpointStatus struct {
+	URL     string
+	Up      bool
+	Latency time.Duration
+}
+
+func (p *EndpointPool) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// init creates the endpoints from URLs on first use, p.mu must be held
+func (p *EndpointPool) init() {
+	if p.endpoints != nil {
+		return
+	}
+	p.endpoints = make([]*endpoint, 0, len(p.URLs))
+	for _, url := range p.URLs {
+		p.endpoints = append(p.endpoints, &endpoint{url: url})
+	}
+}
+
+func (p *EndpointPool) Status() []EndpointStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.init()
+	statuses := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		statuses = append(statuses, EndpointStatus{URL: e.url, Up: !e.down, Latency: e.latency})
+	}
+	return statuses
+}
+
+// order returns the endpoints to try for a request. A down endpoint due for
+// a probe is included and not probed again for ProbeInterval.
+func (p *EndpointPool) order() []*endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.init()
+	n := len(p.endpoints)
+	if n == 0 {
+		return nil
+	}
+
+	now := p.clock()
+	start := p.next % n
+	p.next++
+	rotated := make([]*endpoint, 0, n)
+	available := make([]*endpoint, 0, n)
+	for i := 0; i < n; i++ {
+		e := p.endpoints[(start+i)%n]
+		rotated = append(rotated, e)
+		if !e.down {
+			available = append(available, e)
+		} else if !now.Before(e.nextProbe) {
+			e.nextProbe = now.Add(p.probeInterval())
+			available = append(available, e)
+		}
+	}
+	if len(available) == 0 {
+		available = rotated
+	}
+
+	if p.Balance == LeastLatency {
+		sort.SliceStable(available, func(i, j int) bool {
+			a, b := available[i], available[j]
+			if (a.samples == 0) != (b.samples == 0) {
+				return a.samples == 0
+			}
+			return a.latency < b.latency
+		})
+	}
+	return available
+}
+
+func (p *EndpointPool) probeInterval() time.Duration {
+	if p.ProbeInterval <= 0 {
+		return defaultProbeInterval
+	}
+	return p.ProbeInterval
+}
+
+// report records the outcome of a request to the endpoint
+func (p *EndpointPool) report(e *endpoint, latency time.Duration, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !failed {
+		e.failures, e.down = 0, false
+		if e.samples == 0 {
+			e.latency = latency
+		} else {
+			e.latency += (latency - e.latency) / 4
+		}
+		e.samples++
+		return
+	}
+
+	threshold := p.FailThreshold
+	if threshold <= 0 {
+		threshold = defaultFailThreshold
+	}
+	e.failures++
+	if e.down || e.failures >= threshold {
+		e.down = true
+		e.nextProbe = p.clock().Add(p.probeInterval())
+	}
+}
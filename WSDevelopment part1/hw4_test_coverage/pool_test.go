@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// backend serves the dataset after delay, or fails with 500 while failing
+// is set
+type backend struct {
+	*httptest.Server
+	calls   int32
+	failing int32
+	delay   time.Duration
+}
+
+func newBackend(delay time.Duration) *backend {
+	b := &backend{delay: delay}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&b.calls, 1)
+		time.Sleep(b.delay)
+		if atomic.LoadInt32(&b.failing) != 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		testSearchServer.ServeHTTP(w, r)
+	}))
+	return b
+}
+
+func (b *backend) Calls() int {
+	return int(atomic.SwapInt32(&b.calls, 0))
+}
+
+func (b *backend) SetFailing(failing bool) {
+	var v int32
+	if failing {
+		v = 1
+	}
+	atomic.StoreInt32(&b.failing, v)
+}
+
+func backendURLs(backends ...*backend) []string {
+	urls := make([]string, 0, len(backends))
+	for _, b := range backends {
+		urls = append(urls, b.URL)
+	}
+	return urls
+}
+
+func findN(t *testing.T, cl *SearchClient, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := cl.FindUsers(retryRequest); err != nil {
+			t.Fatalf("Request %d: %v", i, err)
+		}
+	}
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	backends := []*backend{newBackend(0), newBackend(0), newBackend(0)}
+	for _, b := range backends {
+		defer b.Close()
+	}
+	cl := &SearchClient{AccessToken: "1234", Endpoints: &EndpointPool{URLs: backendURLs(backends...)}}
+	findN(t, cl, 9)
+	for i, b := range backends {
+		if calls := b.Calls(); calls != 3 {
+			t.Errorf("Backend %d: expected 3 calls, got %d", i, calls)
+		}
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	good, bad := newBackend(0), newBackend(0)
+	defer good.Close()
+	defer bad.Close()
+	bad.SetFailing(true)
+
+	clock := &fakeClock{now: time.Now()}
+	pool := &EndpointPool{URLs: backendURLs(bad, good), FailThreshold: 2, ProbeInterval: time.Minute, now: clock.Now}
+	cl := &SearchClient{AccessToken: "1234", Endpoints: pool}
+
+	// bad is tried first on every other request until it is down
+	findN(t, cl, 6)
+	if calls := bad.Calls(); calls != 2 {
+		t.Errorf("Expected 2 calls to the failing backend, got %d", calls)
+	}
+	if good.Calls() != 6 {
+		t.Error("Expected every request to fail over")
+	}
+	if status := pool.Status(); status[0].Up || !status[1].Up {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	// one probe per interval
+	clock.now = clock.now.Add(time.Minute)
+	findN(t, cl, 4)
+	if calls := bad.Calls(); calls != 1 {
+		t.Errorf("Expected a single probe, got %d", calls)
+	}
+
+	bad.SetFailing(false)
+	clock.now = clock.now.Add(time.Minute)
+	findN(t, cl, 4)
+	if calls := bad.Calls(); calls != 2 {
+		t.Errorf("Expected the recovered backend to get requests again, got %d", calls)
+	}
+	if status := pool.Status(); !status[0].Up {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestPoolAllDown(t *testing.T) {
+	first, second := newBackend(0), newBackend(0)
+	defer first.Close()
+	defer second.Close()
+	first.SetFailing(true)
+	second.SetFailing(true)
+
+	pool := &EndpointPool{URLs: backendURLs(first, second), FailThreshold: 1}
+	cl := &SearchClient{AccessToken: "1234", Endpoints: pool, Options: ClientOptions{Retry: RetryPolicy{Attempts: 2}}}
+	if _, err := cl.FindUsers(retryRequest); err == nil {
+		t.Fatal("Expected error")
+	}
+	if first.Calls() != 2 || second.Calls() != 2 {
+		t.Error("Expected down backends to be tried anyway")
+	}
+	if status := pool.Status(); status[0].Up || status[1].Up {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	empty := &SearchClient{AccessToken: "1234", Endpoints: &EndpointPool{}}
+	if _, err := empty.FindUsers(retryRequest); err == nil {
+		t.Error("Expected error without endpoints")
+	}
+}
+
+func TestPoolUnreachable(t *testing.T) {
+	good, closed := newBackend(0), newBackend(0)
+	defer good.Close()
+	closed.Close()
+
+	pool := &EndpointPool{URLs: backendURLs(closed, good), FailThreshold: 1}
+	cl := &SearchClient{AccessToken: "1234", Endpoints: pool}
+	findN(t, cl, 2)
+	if good.Calls() != 2 {
+		t.Error("Expected requests to fail over")
+	}
+	if status := pool.Status(); status[0].Up || !status[1].Up {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestPoolLeastLatency(t *testing.T) {
+	slow, fast := newBackend(30*time.Millisecond), newBackend(0)
+	defer slow.Close()
+	defer fast.Close()
+	pool := &EndpointPool{URLs: backendURLs(slow, fast), Balance: LeastLatency}
+	cl := &SearchClient{AccessToken: "1234", Endpoints: pool}
+
+	// both are measured first
+	findN(t, cl, 2)
+	if slow.Calls() != 1 || fast.Calls() != 1 {
+		t.Fatal("Expected every backend to be measured")
+	}
+	findN(t, cl, 5)
+	if calls := fast.Calls(); calls != 5 {
+		t.Errorf("Expected the fast backend to get all requests, got %d", calls)
+	}
+	if status := pool.Status(); status[0].Latency <= status[1].Latency {
+		t.Errorf("Unexpected latencies %+v", status)
+	}
+}
+
+func TestPoolCanceled(t *testing.T) {
+	slow := newBackend(100 * time.Millisecond)
+	defer slow.Close()
+	pool := &EndpointPool{URLs: backendURLs(slow), FailThreshold: 1}
+	cl := &SearchClient{AccessToken: "1234", Endpoints: pool}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cl.FindUsersContext(ctx, retryRequest); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline error, got %v", err)
+	}
+	expected := []EndpointStatus{{URL: slow.URL, Up: true}}
+	if status := pool.Status(); !reflect.DeepEqual(status, expected) {
+		t.Errorf("Canceled request changed the status %+v", status)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import "strings"
+
+type FilterOp string
+
+const (
+	FilterEquals FilterOp = "eq"
+	// FilterFoldEquals and FilterFoldContains ignore case
+	FilterFoldEquals   FilterOp = "ieq"
+	FilterContains     FilterOp = "contains"
+	FilterFoldContains FilterOp = "icontains"
+	// comparisons of Id and Age
+	FilterLess         FilterOp = "lt"
+	FilterLessEqual    FilterOp = "lte"
+	FilterGreater      FilterOp = "gt"
+	FilterGreaterEqual FilterOp = "gte"
+)
+
+// Filter is a condition on a User field, all filters of a request must
+// hold. It is sent as a filter parameter "Field:op:value".
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value string
+}
+
+func (f Filter) String() string {
+	return f.Field + ":" + string(f.Op) + ":" + f.Value
+}
+
+// SortKey is sent as a part of the sort parameter "Age:desc,Name:asc".
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+func (k SortKey) String() string {
+	if k.Desc {
+		return k.Field + ":desc"
+	}
+	return k.Field + ":asc"
+}
+
+func encodeSort(keys []SortKey) string {
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key.String())
+	}
+	return strings.Join(parts, ",")
+}
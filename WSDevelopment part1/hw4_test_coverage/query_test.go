@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFindUsersFilters(t *testing.T) {
+	ts := httptest.NewServer(testSearchServer)
+	defer ts.Close()
+	cl := &SearchClient{AccessToken: "1234", URL: ts.URL}
+
+	req := SearchRequest{
+		Limit: 25,
+		Filters: []Filter{
+			{Field: "Gender", Op: FilterEquals, Value: "female"},
+			{Field: "Age", Op: FilterGreaterEqual, Value: "30"},
+			{Field: "Age", Op: FilterLess, Value: "40"},
+		},
+		Sort: []SortKey{{Field: "Age", Desc: true}, {Field: "Name"}},
+	}
+	var all []User
+	for it := cl.Iter(context.Background(), req); it.Next(); {
+		all = append(all, it.User())
+	}
+	if len(all) == 0 {
+		t.Fatal("No users found")
+	}
+	for i, u := range all {
+		if u.Gender != "female" || u.Age < 30 || u.Age >= 40 {
+			t.Errorf("User does not match filters: %+v", u)
+		}
+		if i > 0 && (all[i-1].Age < u.Age || all[i-1].Age == u.Age && all[i-1].Name > u.Name) {
+			t.Errorf("Users not sorted: %v before %v", all[i-1], u)
+		}
+	}
+
+	// filters combine with the old query, which is case sensitive
+	resp, err := cl.FindUsers(SearchRequest{Limit: 25, Query: "rebekah", OrderBy: 1})
+	if err != nil || len(resp.Users) != 0 {
+		t.Errorf("Unexpected result %v %v", resp, err)
+	}
+	resp, err = cl.FindUsers(SearchRequest{Limit: 25, Query: "e", Filters: []Filter{{Field: "Name", Op: FilterFoldContains, Value: "REBEKAH"}}, OrderBy: 1})
+	if err != nil || len(resp.Users) != 1 || resp.Users[0].Id != 27 {
+		t.Errorf("Unexpected result %v %v", resp, err)
+	}
+
+	for _, c := range []struct {
+		req   SearchRequest
+		field string
+		error string
+	}{
+		{SearchRequest{Filters: []Filter{{Field: "Age", Op: FilterContains, Value: "3"}}}, "Filters", `bad request: Filters filter "Age:contains:3": bad operation for Age`},
+		{SearchRequest{Sort: []SortKey{{Field: "About"}}}, "Sort", `bad request: Sort sort "About:asc": unknown field About`},
+	} {
+		var badReq *BadRequestError
+		if _, err := cl.FindUsers(c.req); !errors.As(err, &badReq) || badReq.Field != c.field || err.Error() != c.error {
+			t.Errorf("[%s] Unexpected error %v", c.field, err)
+		}
+	}
+}
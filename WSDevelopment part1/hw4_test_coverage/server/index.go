@@ -0,0 +1,131 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// textIndex is an inverted index of the first names, last names and about
+// texts of users, documents are indexes in Users.Users.
+type textIndex struct {
+	// terms are sorted for prefix matching
+	terms     []string
+	postings  map[string][]posting
+	lengths   []int
+	avgLength float64
+}
+
+type posting struct {
+	doc  int
+	freq int
+}
+
+// scoredDoc is a document matching a query with its BM25 score
+type scoredDoc struct {
+	doc   int
+	score float64
+}
+
+// tokenize splits s into lower case words of letters and digits
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func newTextIndex(users Users) *textIndex {
+	idx := &textIndex{
+		postings: make(map[string][]posting),
+		lengths:  make([]int, len(users.Users)),
+	}
+	total := 0
+	for doc, user := range users.Users {
+		freqs := make(map[string]int)
+		for _, text := range []string{user.FirstName, user.LastName, user.About} {
+			for _, term := range tokenize(text) {
+				freqs[term]++
+				idx.lengths[doc]++
+			}
+		}
+		total += idx.lengths[doc]
+		for term, freq := range freqs {
+			if _, ok := idx.postings[term]; !ok {
+				idx.terms = append(idx.terms, term)
+			}
+			idx.postings[term] = append(idx.postings[term], posting{doc, freq})
+		}
+	}
+	sort.Strings(idx.terms)
+	if len(idx.lengths) > 0 {
+		idx.avgLength = float64(total) / float64(len(idx.lengths))
+	}
+	return idx
+}
+
+// expand returns the terms starting with prefix
+func (idx *textIndex) expand(prefix string) []string {
+	start := sort.SearchStrings(idx.terms, prefix)
+	end := start
+	for end < len(idx.terms) && strings.HasPrefix(idx.terms[end], prefix) {
+		end++
+	}
+	return idx.terms[start:end]
+}
+
+// search returns the documents containing a word starting with every word
+// of the query, in document order. A query without words matches all
+// documents with zero scores.
+func (idx *textIndex) search(query string) []scoredDoc {
+	words := tokenize(query)
+	if len(words) == 0 {
+		docs := make([]scoredDoc, len(idx.lengths))
+		for doc := range docs {
+			docs[doc].doc = doc
+		}
+		return docs
+	}
+
+	unique := make(map[string]bool, len(words))
+	scores := make(map[int]float64)
+	matched := make(map[int]int)
+	n := float64(len(idx.lengths))
+	for _, word := range words {
+		if unique[word] {
+			continue
+		}
+		unique[word] = true
+
+		found := make(map[int]bool)
+		for _, term := range idx.expand(word) {
+			postings := idx.postings[term]
+			df := float64(len(postings))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for _, p := range postings {
+				tf := float64(p.freq)
+				norm := bm25K1 * (1 - bm25B + bm25B*float64(idx.lengths[p.doc])/idx.avgLength)
+				scores[p.doc] += idf * tf * (bm25K1 + 1) / (tf + norm)
+				found[p.doc] = true
+			}
+		}
+		for doc := range found {
+			matched[doc]++
+		}
+	}
+
+	docs := make([]scoredDoc, 0)
+	for doc, count := range matched {
+		if count == len(unique) {
+			docs = append(docs, scoredDoc{doc, scores[doc]})
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].doc < docs[j].doc })
+	return docs
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Ipsum, DOLOR-sit  amet2 Ünïcode!")
+	expected := []string{"ipsum", "dolor", "sit", "amet2", "ünïcode"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestTextIndexSearch(t *testing.T) {
+	idx := newTextIndex(Users{Users: []RowUser{
+		{FirstName: "Anna", LastName: "Lee", About: "Loves apples and apple pie"},
+		{FirstName: "Bob", LastName: "Apple", About: "Grows pears, sells them, and sells plums in a very long about text"},
+		{FirstName: "Carl", LastName: "Pear", About: "Pear"},
+		{FirstName: "Dana", LastName: "Stone", About: "Nothing"},
+	}})
+
+	for _, c := range []struct {
+		query string
+		docs  []int
+	}{
+		{"", []int{0, 1, 2, 3}},
+		{"...", []int{0, 1, 2, 3}},
+		{"apple", []int{0, 1}},
+		{"APPL", []int{0, 1}},
+		{"pear", []int{1, 2}},
+		{"pear sells", []int{1}},
+		{"pear pear", []int{1, 2}},
+		{"ear", nil},
+		{"zebra", nil},
+	} {
+		docs := []int(nil)
+		for _, d := range idx.search(c.query) {
+			docs = append(docs, d.doc)
+		}
+		if !reflect.DeepEqual(docs, c.docs) {
+			t.Errorf("%q: expected %v, got %v", c.query, c.docs, docs)
+		}
+	}
+
+	// a short text with the word twice beats a long one with it once
+	scores := idx.search("pear")
+	if scores[1].score <= scores[0].score {
+		t.Errorf("Expected the short document to rank first %v", scores)
+	}
+	// rare words weigh more
+	if rare, common := idx.search("grows")[0].score, idx.search("and")[1].score; rare <= common {
+		t.Errorf("Expected a rare word to score more, %v <= %v", rare, common)
+	}
+
+	if docs := newTextIndex(Users{}).search("any"); len(docs) != 0 {
+		t.Errorf("Unexpected documents in an empty index %v", docs)
+	}
+}
+
+func TestSearchServerRelevance(t *testing.T) {
+	find := func(orderBy string) []int {
+		w := serverRequest(testSearchServer, "1234", "limit=100&offset=0&query=NULLA+ex&order_field=relevance&order_by="+orderBy)
+		users := []User{}
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatalf("%s: %v", w.Body, err)
+		}
+		ids := []int{}
+		for _, u := range users {
+			ids = append(ids, u.Id)
+		}
+		return ids
+	}
+
+	best, worst, asIs := find("1"), find("-1"), find("0")
+	if len(best) == 0 || len(best) != len(worst) || len(best) != len(asIs) {
+		t.Fatalf("Unexpected results %v %v %v", best, worst, asIs)
+	}
+	scores := map[int]float64{}
+	for _, d := range testSearchServer.index.search("nulla ex") {
+		row := testSearchServer.users.Users[d.doc]
+		scores[row.ID] = d.score
+		about := strings.ToLower(row.About)
+		if !strings.Contains(about, "nulla") || !strings.Contains(about, "ex") {
+			t.Errorf("User %d does not match", row.ID)
+		}
+	}
+	for i := 1; i < len(best); i++ {
+		if scores[best[i-1]] < scores[best[i]] || scores[worst[i-1]] > scores[worst[i]] || asIs[i-1] > asIs[i] {
+			t.Fatalf("Unexpected order %v %v %v", best, worst, asIs)
+		}
+	}
+}
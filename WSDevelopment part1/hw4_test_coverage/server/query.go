@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FilterOp is the operation of a filter parameter "Field:op:value"
+type FilterOp string
+
+const (
+	FilterEquals FilterOp = "eq"
+	// FilterFoldEquals and FilterFoldContains ignore case
+	FilterFoldEquals   FilterOp = "ieq"
+	FilterContains     FilterOp = "contains"
+	FilterFoldContains FilterOp = "icontains"
+	// comparisons of Id and Age
+	FilterLess         FilterOp = "lt"
+	FilterLessEqual    FilterOp = "lte"
+	FilterGreater      FilterOp = "gt"
+	FilterGreaterEqual FilterOp = "gte"
+)
+
+var (
+	stringFields = map[string]func(u *User) string{
+		"Name":   func(u *User) string { return u.Name },
+		"About":  func(u *User) string { return u.About },
+		"Gender": func(u *User) string { return u.Gender },
+	}
+	intFields = map[string]func(u *User) int{
+		"Id":  func(u *User) int { return u.Id },
+		"Age": func(u *User) int { return u.Age },
+	}
+)
+
+// userFilter is a parsed Filter
+type userFilter func(u *User) bool
+
+func parseFilter(s string) (userFilter, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("filter %q: expected Field:op:value", s)
+	}
+	field, op, value := parts[0], FilterOp(parts[1]), parts[2]
+
+	if get, ok := stringFields[field]; ok {
+		folded := strings.ToLower(value)
+		switch op {
+		case FilterEquals:
+			return func(u *User) bool { return get(u) == value }, nil
+		case FilterFoldEquals:
+			return func(u *User) bool { return strings.EqualFold(get(u), value) }, nil
+		case FilterContains:
+			return func(u *User) bool { return strings.Contains(get(u), value) }, nil
+		case FilterFoldContains:
+			return func(u *User) bool { return strings.Contains(strings.ToLower(get(u)), folded) }, nil
+		}
+		return nil, fmt.Errorf("filter %q: bad operation for %s", s, field)
+	}
+
+	get, ok := intFields[field]
+	if !ok {
+		return nil, fmt.Errorf("filter %q: unknown field %s", s, field)
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %s needs a number", s, field)
+	}
+	switch op {
+	case FilterEquals:
+		return func(u *User) bool { return get(u) == n }, nil
+	case FilterLess:
+		return func(u *User) bool { return get(u) < n }, nil
+	case FilterLessEqual:
+		return func(u *User) bool { return get(u) <= n }, nil
+	case FilterGreater:
+		return func(u *User) bool { return get(u) > n }, nil
+	case FilterGreaterEqual:
+		return func(u *User) bool { return get(u) >= n }, nil
+	}
+	return nil, fmt.Errorf("filter %q: bad operation for %s", s, field)
+}
+
+func parseFilters(params []string) ([]userFilter, error) {
+	filters := make([]userFilter, 0, len(params))
+	for _, param := range params {
+		filter, err := parseFilter(param)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// userLess returns the ascending order of a sortable field
+func userLess(field string) (func(a, b *User) bool, bool) {
+	if get, ok := stringFields[field]; ok && field != "About" {
+		return func(a, b *User) bool { return get(a) < get(b) }, true
+	}
+	if get, ok := intFields[field]; ok {
+		return func(a, b *User) bool { return get(a) < get(b) }, true
+	}
+	return nil, false
+}
+
+// compareKey orders users by one key, -1, 0 or 1
+type compareKey func(a, b *User) int
+
+func parseSort(s string) ([]compareKey, error) {
+	keys := make([]compareKey, 0)
+	for _, part := range strings.Split(s, ",") {
+		field, dir := part, "asc"
+		if i := strings.IndexByte(part, ':'); i >= 0 {
+			field, dir = part[:i], part[i+1:]
+		}
+		less, ok := userLess(field)
+		if !ok {
+			return nil, fmt.Errorf("sort %q: unknown field %s", s, field)
+		}
+		var sign int
+		switch dir {
+		case "asc":
+			sign = 1
+		case "desc":
+			sign = -1
+		default:
+			return nil, fmt.Errorf("sort %q: bad direction %s", s, dir)
+		}
+		keys = append(keys, func(a, b *User) int {
+			switch {
+			case less(a, b):
+				return -sign
+			case less(b, a):
+				return sign
+			}
+			return 0
+		})
+	}
+	return keys, nil
+}
+
+func sortUsersBy(users []User, keys []compareKey) {
+	sort.SliceStable(users, func(i, j int) bool {
+		for _, key := range keys {
+			if c := key(&users[i], &users[j]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
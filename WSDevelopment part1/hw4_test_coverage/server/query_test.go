@@ -0,0 +1,80 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	user := &User{Id: 7, Name: "Boyd Wolf", Age: 22, About: "Nulla: cillum", Gender: "male"}
+	cases := map[string]bool{
+		"Name:eq:Boyd Wolf":        true,
+		"Name:eq:boyd wolf":        false,
+		"Name:ieq:boyd wolf":       true,
+		"About:contains:la: c":     true,
+		"About:contains:NULLA":     false,
+		"About:icontains:NULLA: C": true,
+		"Gender:eq:male":           true,
+		"Gender:eq:female":         false,
+		"Age:gte:22":               true,
+		"Age:gt:22":                false,
+		"Age:lte:21":               false,
+		"Age:lt:30":                true,
+		"Id:eq:7":                  true,
+		"Id:eq:-7":                 false,
+	}
+	for s, expected := range cases {
+		filter, err := parseFilter(s)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", s, err)
+			continue
+		}
+		if filter(user) != expected {
+			t.Errorf("%q: expected %v", s, expected)
+		}
+	}
+
+	for _, bad := range []string{"Name", "Name:eq", "Email:eq:x", "Name:gt:a", "Age:contains:2", "Age:eq:x", "Id:ieq:1"} {
+		if _, err := parseFilter(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	users := []User{
+		{Id: 1, Name: "B", Age: 30, Gender: "male"},
+		{Id: 2, Name: "A", Age: 20, Gender: "female"},
+		{Id: 3, Name: "C", Age: 30, Gender: "female"},
+		{Id: 4, Name: "A", Age: 30, Gender: "male"},
+	}
+	cases := map[string][]int{
+		"Age:desc,Name":       {4, 1, 3, 2},
+		"Gender:asc,Age:desc": {3, 2, 1, 4},
+		"Name,Id:desc":        {4, 2, 1, 3},
+		"Age:asc":             {2, 1, 3, 4},
+		"Gender:desc,Id:desc": {4, 1, 3, 2},
+	}
+	for s, expected := range cases {
+		keys, err := parseSort(s)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", s, err)
+			continue
+		}
+		sorted := append([]User(nil), users...)
+		sortUsersBy(sorted, keys)
+		ids := []int{}
+		for _, u := range sorted {
+			ids = append(ids, u.Id)
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("%q: expected %v, got %v", s, expected, ids)
+		}
+	}
+
+	for _, bad := range []string{"About", "Age:up", "Age,", "Email:asc"} {
+		if _, err := parseSort(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+// Package server is the search server of SearchClient. It answers FindUsers
+// requests from a dataset of users loaded in memory.
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User is a user as sent to clients
+type User struct {
+	Id     int
+	Name   string
+	Age    int
+	About  string
+	Gender string
+
+	// optional fields are nil unless requested with the fields parameter
+	Guid          *string `json:",omitempty"`
+	IsActive      *bool   `json:",omitempty"`
+	Balance       *string `json:",omitempty"`
+	Picture       *string `json:",omitempty"`
+	EyeColor      *string `json:",omitempty"`
+	Company       *string `json:",omitempty"`
+	Email         *string `json:",omitempty"`
+	Phone         *string `json:",omitempty"`
+	Address       *string `json:",omitempty"`
+	Registered    *string `json:",omitempty"`
+	FavoriteFruit *string `json:",omitempty"`
+}
+
+// SearchErrorResponse is the body of error answers
+type SearchErrorResponse struct {
+	Error string
+}
+
+const (
+	ErrorBadOrderField = `OrderField invalid`
+
+	// OrderFieldRelevance orders users by relevance of their words to the
+	// query
+	OrderFieldRelevance = "relevance"
+)
+
+type Users struct {
+	XMLName xml.Name  `xml:"root"`
+	Users   []RowUser `xml:"row"`
+}
+
+// RowUser is a user as stored in dataset.xml. JSON and CSV datasets use the
+// same field names.
+type RowUser struct {
+	ID             int    `xml:"id" json:"id"`
+	Guid           string `xml:"guid" json:"guid"`
+	IsActive       bool   `xml:"isActive" json:"isActive"`
+	Balance        string `xml:"balance" json:"balance"`
+	Picture        string `xml:"picture" json:"picture"`
+	Age            string `xml:"age" json:"age"`
+	EyeColor       string `xml:"eyeColor" json:"eyeColor"`
+	FirstName      string `xml:"first_name" json:"first_name"`
+	LastName       string `xml:"last_name" json:"last_name"`
+	Gender         string `xml:"gender" json:"gender"`
+	Company        string `xml:"company" json:"company"`
+	Email          string `xml:"email" json:"email"`
+	Phone          string `xml:"phone" json:"phone"`
+	Address        string `xml:"address" json:"address"`
+	About          string `xml:"about" json:"about"`
+	Registered     string `xml:"registered" json:"registered"`
+	FavouriteFruit string `xml:"favoriteFruit" json:"favoriteFruit"`
+}
+
+func (ru *RowUser) SelectQuery(query string) bool {
+	return strings.Contains(ru.About, query) || strings.Contains(ru.FirstName, query) || strings.Contains(ru.LastName, query)
+}
+
+// optionalFields fill the User fields returned only if requested, by their
+// names in the fields parameter
+var optionalFields = map[string]struct {
+	// field is the User field, as limited by TokenClaims.Fields
+	field string
+	set   func(u *User, ru *RowUser)
+}{
+	"guid":          {"Guid", func(u *User, ru *RowUser) { u.Guid = &ru.Guid }},
+	"isActive":      {"IsActive", func(u *User, ru *RowUser) { u.IsActive = &ru.IsActive }},
+	"balance":       {"Balance", func(u *User, ru *RowUser) { u.Balance = &ru.Balance }},
+	"picture":       {"Picture", func(u *User, ru *RowUser) { u.Picture = &ru.Picture }},
+	"eyeColor":      {"EyeColor", func(u *User, ru *RowUser) { u.EyeColor = &ru.EyeColor }},
+	"company":       {"Company", func(u *User, ru *RowUser) { u.Company = &ru.Company }},
+	"email":         {"Email", func(u *User, ru *RowUser) { u.Email = &ru.Email }},
+	"phone":         {"Phone", func(u *User, ru *RowUser) { u.Phone = &ru.Phone }},
+	"address":       {"Address", func(u *User, ru *RowUser) { u.Address = &ru.Address }},
+	"registered":    {"Registered", func(u *User, ru *RowUser) { u.Registered = &ru.Registered }},
+	"favoriteFruit": {"FavoriteFruit", func(u *User, ru *RowUser) { u.FavoriteFruit = &ru.FavouriteFruit }},
+}
+
+// parseFields checks the fields parameter, "email,company"
+func parseFields(param string) ([]string, error) {
+	fields := make([]string, 0)
+	for _, name := range strings.Split(param, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := optionalFields[name]; !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// rowToUser fills the base User fields and the optional fields given
+func rowToUser(ru RowUser, fields []string) User {
+	u := User{}
+	u.Id = ru.ID
+	u.Name = ru.FirstName + " " + ru.LastName
+	// unparsable ages are checked by LoadUsers
+	u.Age, _ = strconv.Atoi(ru.Age)
+	u.About = ru.About
+	u.Gender = ru.Gender
+	for _, name := range fields {
+		optionalFields[name].set(&u, &ru)
+	}
+	return u
+}
+
+// selectUsers returns users matching the query and all the filters with
+// the optional fields given
+func selectUsers(users Users, query string, filters []userFilter, fields []string) []User {
+	usersToAnswer := make([]User, 0)
+	for _, rowUser := range users.Users {
+		if query != "" && !rowUser.SelectQuery(query) {
+			continue
+		}
+		if user := rowToUser(rowUser, fields); matchFilters(&user, filters) {
+			usersToAnswer = append(usersToAnswer, user)
+		}
+	}
+	return usersToAnswer
+}
+
+func matchFilters(user *User, filters []userFilter) bool {
+	for _, filter := range filters {
+		if !filter(user) {
+			return false
+		}
+	}
+	return true
+}
+
+// rankUsers is selectUsers with the text index, users are ordered by
+// relevance to the query: best first for orderBy "1", last for "-1", as
+// is for "0"
+func (srv *SearchServer) rankUsers(query string, filters []userFilter, fields []string, orderBy string) ([]User, error) {
+	docs := srv.index.search(query)
+	switch orderBy {
+	case "-1":
+		sort.SliceStable(docs, func(i, j int) bool { return docs[i].score < docs[j].score })
+	case "0":
+	case "1":
+		sort.SliceStable(docs, func(i, j int) bool { return docs[i].score > docs[j].score })
+	default:
+		return nil, fmt.Errorf(errorBadOrderBy)
+	}
+
+	usersToAnswer := make([]User, 0, len(docs))
+	for _, doc := range docs {
+		if user := rowToUser(srv.users.Users[doc.doc], fields); matchFilters(&user, filters) {
+			usersToAnswer = append(usersToAnswer, user)
+		}
+	}
+	return usersToAnswer, nil
+}
+
+const errorBadOrderBy = "BadOrderByValue"
+
+func sortUsers(users []User, orderField string, orderByVal string) ([]User, error) {
+	var less func(a, b *User) bool
+	switch orderField {
+	case "Id":
+		less = func(a, b *User) bool { return a.Id < b.Id }
+	case "Age":
+		less = func(a, b *User) bool { return a.Age < b.Age }
+	case "Name", "":
+		less = func(a, b *User) bool { return a.Name < b.Name }
+	default:
+		return nil, fmt.Errorf(ErrorBadOrderField)
+	}
+
+	// -1 descending, 0 as is, 1 ascending
+	switch orderByVal {
+	case "-1":
+		sort.SliceStable(users, func(i, j int) bool { return less(&users[j], &users[i]) })
+	case "0":
+	case "1":
+		sort.SliceStable(users, func(i, j int) bool { return less(&users[i], &users[j]) })
+	default:
+		return nil, fmt.Errorf(errorBadOrderBy)
+	}
+	return users, nil
+}
+
+// LoadUsers reads a dataset in the dataset.xml format, or a JSON array or
+// CSV file with a header of RowUser fields, chosen by the file extension.
+func LoadUsers(path string) (Users, error) {
+	users := Users{}
+	file, err := os.Open(path)
+	if err != nil {
+		return users, err
+	}
+	defer file.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".xml":
+		err = xml.NewDecoder(file).Decode(&users)
+	case ".json":
+		err = json.NewDecoder(file).Decode(&users.Users)
+	case ".csv":
+		users.Users, err = readCSVUsers(file)
+	default:
+		return users, fmt.Errorf("%s: unknown dataset format %q", path, ext)
+	}
+	if err != nil {
+		return users, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for _, user := range users.Users {
+		if _, err := strconv.Atoi(user.Age); err != nil {
+			return users, fmt.Errorf("%s: user %d: bad age %q", path, user.ID, user.Age)
+		}
+	}
+	return users, nil
+}
+
+func readCSVUsers(r io.Reader) ([]RowUser, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no csv header")
+	}
+
+	// the rows are decoded through JSON to reuse the field names
+	header := records[0]
+	users := make([]RowUser, 0, len(records)-1)
+	for n, record := range records[1:] {
+		fields := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			fields[name] = record[i]
+		}
+		for _, name := range []string{"id", "isActive"} {
+			value, ok := fields[name].(string)
+			if !ok {
+				continue
+			}
+			var parsed interface{}
+			if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+				return nil, fmt.Errorf("line %d: bad %s %q", n+2, name, value)
+			}
+			fields[name] = parsed
+		}
+
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		user := RowUser{}
+		if err := json.Unmarshal(data, &user); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+2, err)
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// SearchServer answers FindUsers requests from a dataset loaded in memory.
+// The query is a case sensitive substring of names or about, or words
+// prefixing their words in any case with the relevance order field.
+// Answers carry an ETag of their content and the dataset load time as
+// Last-Modified, so clients can revalidate them.
+type SearchServer struct {
+	// TokenKey enables tokens signed by SignToken in addition to the static
+	// ones if set
+	TokenKey []byte
+
+	users    Users
+	index    *textIndex
+	tokens   map[string]bool
+	modified time.Time
+}
+
+// staticClaims are the claims of static tokens
+var staticClaims = &TokenClaims{Scope: ScopeSearch + " " + ScopeFilter}
+
+func NewSearchServer(users Users, tokens []string) *SearchServer {
+	srv := &SearchServer{
+		users:  users,
+		index:  newTextIndex(users),
+		tokens: make(map[string]bool, len(tokens)),
+		// Last-Modified has a precision of seconds
+		modified: time.Now().UTC().Truncate(time.Second),
+	}
+	for _, token := range tokens {
+		srv.tokens[token] = true
+	}
+	return srv
+}
+
+// Len returns the number of users served
+func (srv *SearchServer) Len() int {
+	return len(srv.users.Users)
+}
+
+func (srv *SearchServer) writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(SearchErrorResponse{Error: message})
+}
+
+// authorize returns the claims of the request token. Unknown tokens get an
+// empty 401 like before signed tokens, bad signed ones an error body.
+func (srv *SearchServer) authorize(w http.ResponseWriter, r *http.Request) *TokenClaims {
+	token := r.Header.Get("AccessToken")
+	if srv.tokens[token] {
+		return staticClaims
+	}
+	if srv.TokenKey == nil || strings.Count(token, ".") != 2 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+	claims, err := VerifyToken(srv.TokenKey, token, time.Now())
+	switch err {
+	case nil:
+		return claims
+	case errTokenExpired:
+		srv.writeError(w, http.StatusUnauthorized, "TokenExpired")
+	default:
+		srv.writeError(w, http.StatusUnauthorized, "InvalidToken")
+	}
+	return nil
+}
+
+// forbidden returns the 403 error for a request the claims do not allow or
+// an empty string
+func forbidden(claims *TokenClaims, params url.Values) string {
+	if !claims.HasScope(ScopeSearch) {
+		return "MissingScope:" + ScopeSearch
+	}
+	if (len(params["filter"]) > 0 || params.Get("sort") != "") && !claims.HasScope(ScopeFilter) {
+		return "MissingScope:" + ScopeFilter
+	}
+
+	fields := make([]string, 0)
+	if params.Get("query") != "" {
+		fields = append(fields, "Name", "About")
+	}
+	if params.Get("sort") != "" {
+		for _, key := range strings.Split(params.Get("sort"), ",") {
+			fields = append(fields, strings.SplitN(key, ":", 2)[0])
+		}
+	} else if params.Get("order_by") != "0" {
+		switch field := params.Get("order_field"); field {
+		case "":
+			fields = append(fields, "Name")
+		case OrderFieldRelevance:
+			// the query needs Name and About already
+		default:
+			fields = append(fields, field)
+		}
+	}
+	for _, filter := range params["filter"] {
+		fields = append(fields, strings.SplitN(filter, ":", 2)[0])
+	}
+	// fields is checked by parseFields
+	for _, name := range strings.Split(params.Get("fields"), ",") {
+		if optional, ok := optionalFields[strings.TrimSpace(name)]; ok {
+			fields = append(fields, optional.field)
+		}
+	}
+	for _, field := range fields {
+		if !claims.CanSee(field) {
+			return "ForbiddenField:" + field
+		}
+	}
+	return ""
+}
+
+// hideFields clears the fields the claims do not allow
+func hideFields(users []User, claims *TokenClaims) {
+	if len(claims.Fields) == 0 {
+		return
+	}
+	for i := range users {
+		u := &users[i]
+		if !claims.CanSee("Name") {
+			u.Name = ""
+		}
+		if !claims.CanSee("Age") {
+			u.Age = 0
+		}
+		if !claims.CanSee("About") {
+			u.About = ""
+		}
+		if !claims.CanSee("Gender") {
+			u.Gender = ""
+		}
+	}
+}
+
+func (srv *SearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	claims := srv.authorize(w, r)
+	if claims == nil {
+		return
+	}
+
+	urlParams := r.URL.Query()
+	query := urlParams.Get("query")
+	orderField := urlParams.Get("order_field")
+	orderByValue := urlParams.Get("order_by")
+
+	offset, err := strconv.Atoi(urlParams.Get("offset"))
+	if err != nil || offset < 0 {
+		srv.writeError(w, http.StatusBadRequest, "BadOffsetValue")
+		return
+	}
+	limit, err := strconv.Atoi(urlParams.Get("limit"))
+	if err != nil || limit < 0 {
+		srv.writeError(w, http.StatusBadRequest, "BadLimitValue")
+		return
+	}
+
+	filters, err := parseFilters(urlParams["filter"])
+	if err != nil {
+		srv.writeError(w, http.StatusBadRequest, "BadFilter:"+err.Error())
+		return
+	}
+	fields, err := parseFields(urlParams.Get("fields"))
+	if err != nil {
+		srv.writeError(w, http.StatusBadRequest, "BadFields")
+		return
+	}
+	if message := forbidden(claims, urlParams); message != "" {
+		srv.writeError(w, http.StatusForbidden, message)
+		return
+	}
+
+	// sort replaces order_field and order_by
+	var ans []User
+	switch sortParam := urlParams.Get("sort"); {
+	case sortParam != "":
+		keys, err := parseSort(sortParam)
+		if err != nil {
+			srv.writeError(w, http.StatusBadRequest, "BadSort:"+err.Error())
+			return
+		}
+		ans = selectUsers(srv.users, query, filters, fields)
+		sortUsersBy(ans, keys)
+	case orderField == OrderFieldRelevance:
+		if ans, err = srv.rankUsers(query, filters, fields, orderByValue); err != nil {
+			srv.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		ans = selectUsers(srv.users, query, filters, fields)
+		if ans, err = sortUsers(ans, orderField, orderByValue); err != nil {
+			switch err.Error() {
+			case ErrorBadOrderField:
+				srv.writeError(w, http.StatusBadRequest, "ErrorBadOrderField")
+			default:
+				srv.writeError(w, http.StatusBadRequest, err.Error())
+			}
+			return
+		}
+	}
+
+	if offset > len(ans) {
+		offset = len(ans)
+	}
+	ans = ans[offset:]
+	if limit < len(ans) {
+		ans = ans[:limit]
+	}
+	hideFields(ans, claims)
+
+	body, err := json.Marshal(ans)
+	if err != nil {
+		srv.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	hash := fnv.New64a()
+	hash.Write(body)
+	etag := fmt.Sprintf(`"%x"`, hash.Sum64())
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", srv.modified.Format(http.TimeFormat))
+	if srv.notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// notModified checks If-None-Match, or If-Modified-Since without it
+func (srv *SearchServer) notModified(r *http.Request, etag string) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !srv.modified.After(since)
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var filePath = "../dataset.xml"
+
+// testSearchServer serves dataset.xml to the "1234" token
+var testSearchServer = func() *SearchServer {
+	users, err := LoadUsers(filePath)
+	if err != nil {
+		panic(err)
+	}
+	return NewSearchServer(users, []string{"1234"})
+}()
+
+func writeDatasets(t *testing.T, users Users) (jsonPath, csvPath string) {
+	dir := t.TempDir()
+	jsonPath, csvPath = filepath.Join(dir, "users.json"), filepath.Join(dir, "users.csv")
+
+	data, err := json.Marshal(users.Users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(jsonPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Create(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	w.Write([]string{"id", "isActive", "age", "first_name", "last_name", "gender", "about", "email"})
+	for _, u := range users.Users {
+		w.Write([]string{strconv.Itoa(u.ID), strconv.FormatBool(u.IsActive), u.Age, u.FirstName, u.LastName, u.Gender, u.About, u.Email})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return jsonPath, csvPath
+}
+
+func TestLoadUsers(t *testing.T) {
+	xmlUsers, err := LoadUsers(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xmlUsers.Users) != 35 {
+		t.Fatalf("expected 35 users, got %d", len(xmlUsers.Users))
+	}
+
+	jsonPath, csvPath := writeDatasets(t, xmlUsers)
+	jsonUsers, err := LoadUsers(jsonPath)
+	if err != nil || !reflect.DeepEqual(jsonUsers.Users, xmlUsers.Users) {
+		t.Errorf("json dataset differs: %v", err)
+	}
+	csvUsers, err := LoadUsers(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(selectUsers(csvUsers, "", nil, nil), selectUsers(xmlUsers, "", nil, nil)) || csvUsers.Users[3].Email != xmlUsers.Users[3].Email {
+		t.Errorf("csv dataset differs")
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"users.txt":  "",
+		"age.json":   `[{"id":1,"age":"old"}]`,
+		"bad.json":   `{`,
+		"empty.csv":  "",
+		"id.csv":     "id,age\nx,1\n",
+		"active.csv": "id,isActive,age\n1,maybe,1\n",
+		"short.csv":  "id,age\n1\n",
+	} {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(content), 0644)
+		if _, err := LoadUsers(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := LoadUsers(filepath.Join(dir, "missing.xml")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+func serverRequest(srv http.Handler, token, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+	req.Header.Set("AccessToken", token)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	return w
+}
+
+func TestSearchServerFields(t *testing.T) {
+	// optional fields are not sent unless requested
+	w := serverRequest(testSearchServer, "1234", "limit=1&offset=0&order_by=0")
+	if strings.Contains(w.Body.String(), "Email") {
+		t.Errorf("Unexpected optional fields %s", w.Body)
+	}
+
+	w = serverRequest(testSearchServer, "1234", "limit=1&offset=0&order_field=Id&order_by=1&fields=email,company")
+	users := []User{}
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil || len(users) != 1 || users[0].Email == nil || users[0].Company == nil || users[0].Phone != nil {
+		t.Errorf("Expected email and company, got %s", w.Body)
+	}
+	if w = serverRequest(testSearchServer, "1234", "limit=1&offset=0&order_by=0&fields=password"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestSearchServerTokens(t *testing.T) {
+	users, _ := LoadUsers(filePath)
+	srv := NewSearchServer(users, []string{"first", "second"})
+	for token, status := range map[string]int{"first": http.StatusOK, "second": http.StatusOK, "": http.StatusUnauthorized, "1234": http.StatusUnauthorized} {
+		if w := serverRequest(srv, token, "limit=1&offset=0&order_by=0"); w.Code != status {
+			t.Errorf("%q: expected status %d, got %d", token, status, w.Code)
+		}
+	}
+}
+
+func TestSearchServerParams(t *testing.T) {
+	cases := []struct {
+		query string
+		ids   []int
+		error string
+	}{
+		{"limit=3&offset=0&order_field=Id&order_by=-1", []int{34, 33, 32}, ""},
+		{"limit=2&offset=1&order_field=Age&order_by=1", []int{15, 23}, ""},
+		{"limit=2&offset=0&order_by=0", []int{0, 1}, ""},
+		{"limit=5&offset=34&order_field=Id&order_by=1", []int{34}, ""},
+		{"limit=5&offset=100&order_by=1", []int{}, ""},
+		{"limit=5&offset=0&query=Rebekah&order_by=1", []int{27}, ""},
+		{"limit=x&offset=0&order_by=1", nil, "BadLimitValue"},
+		{"limit=1&offset=-1&order_by=1", nil, "BadOffsetValue"},
+		{"limit=1&offset=0&order_by=2", nil, "BadOrderByValue"},
+		{"limit=1&offset=0&order_field=About&order_by=1", nil, "ErrorBadOrderField"},
+		{"limit=1&offset=0&filter=Age:contains:3", nil, `BadFilter:filter "Age:contains:3": bad operation for Age`},
+		{"limit=1&offset=0&filter=Eyes:eq:x", nil, `BadFilter:filter "Eyes:eq:x": unknown field Eyes`},
+		{"limit=1&offset=0&sort=About", nil, `BadSort:sort "About": unknown field About`},
+	}
+	for _, c := range cases {
+		w := serverRequest(testSearchServer, "1234", c.query)
+		if c.error != "" {
+			errResp := SearchErrorResponse{}
+			json.Unmarshal(w.Body.Bytes(), &errResp)
+			if w.Code != http.StatusBadRequest || errResp.Error != c.error {
+				t.Errorf("%s: expected %s, got %d %s", c.query, c.error, w.Code, w.Body)
+			}
+			continue
+		}
+
+		users := []User{}
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatalf("%s: %v", c.query, err)
+		}
+		ids := make([]int, 0)
+		for _, u := range users {
+			ids = append(ids, u.Id)
+		}
+		if !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("%s: expected users %v, got %v", c.query, c.ids, ids)
+		}
+	}
+}
+
+func TestSearchServerConditional(t *testing.T) {
+	const query = "limit=2&offset=0&order_by=0"
+	w := serverRequest(testSearchServer, "1234", query)
+	etag, modified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if w.Code != http.StatusOK || etag == "" || modified == "" {
+		t.Fatalf("Expected validators, got %d %v", w.Code, w.Header())
+	}
+	if other := serverRequest(testSearchServer, "1234", "limit=3&offset=0&order_by=0"); other.Header().Get("ETag") == etag {
+		t.Errorf("Different answers have the same ETag %s", etag)
+	}
+
+	for _, c := range []struct {
+		header, value string
+		status        int
+	}{
+		{"If-None-Match", etag, http.StatusNotModified},
+		{"If-None-Match", `"other", W/` + etag, http.StatusNotModified},
+		{"If-None-Match", "*", http.StatusNotModified},
+		{"If-None-Match", `"other"`, http.StatusOK},
+		{"If-Modified-Since", modified, http.StatusNotModified},
+		{"If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT", http.StatusOK},
+		{"If-Modified-Since", "yesterday", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		req.Header.Set("AccessToken", "1234")
+		req.Header.Set(c.header, c.value)
+		w := httptest.NewRecorder()
+		testSearchServer.ServeHTTP(w, req)
+		if w.Code != c.status {
+			t.Errorf("%s %s: expected status %d, got %d", c.header, c.value, c.status, w.Code)
+		}
+		if c.status == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%s %s: unexpected body %q", c.header, c.value, w.Body)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Scopes of signed tokens. Static tokens of SearchServer have all of them.
+const (
+	// ScopeSearch allows query, order_field and order_by
+	ScopeSearch = "users:search"
+	// ScopeFilter allows the filter and sort parameters
+	ScopeFilter = "users:filter"
+)
+
+// TokenClaims is the payload of a signed token, a JWT signed with HS256.
+type TokenClaims struct {
+	Subject string `json:"sub,omitempty"`
+	// ExpiresAt is a unix time, required
+	ExpiresAt int64 `json:"exp"`
+	// Scope is a space separated list of scopes
+	Scope string `json:"scope"`
+	// Fields limits the User fields the token can search by and see, Id
+	// is always visible. All fields if empty.
+	Fields []string `json:"fields,omitempty"`
+}
+
+func (c *TokenClaims) HasScope(scope string) bool {
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CanSee reports whether the token allows the User field
+func (c *TokenClaims) CanSee(field string) bool {
+	if len(c.Fields) == 0 || field == "Id" {
+		return true
+	}
+	for _, f := range c.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errTokenExpired = errors.New("token expired")
+)
+
+var (
+	tokenEncoding = base64.RawURLEncoding
+	tokenHeader   = tokenEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+)
+
+func SignToken(key []byte, claims TokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := tokenHeader + "." + tokenEncoding.EncodeToString(payload)
+	return signed + "." + tokenEncoding.EncodeToString(tokenMAC(key, signed)), nil
+}
+
+func tokenMAC(key []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+// VerifyToken returns the claims of a token signed with key that has not
+// expired at now.
+func VerifyToken(key []byte, token string, now time.Time) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+	// the header is fixed, so no other algorithms can sneak in
+	if parts[0] != tokenHeader {
+		return nil, errInvalidToken
+	}
+	sig, err := tokenEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, tokenMAC(key, parts[0]+"."+parts[1])) {
+		return nil, errInvalidToken
+	}
+	payload, err := tokenEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	claims := &TokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil || claims.ExpiresAt == 0 {
+		return nil, errInvalidToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, errTokenExpired
+	}
+	return claims, nil
+}
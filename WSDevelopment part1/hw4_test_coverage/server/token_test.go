@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+var tokenKey = []byte("secret")
+
+func TestVerifyToken(t *testing.T) {
+	now := time.Now()
+	claims := TokenClaims{Subject: "dashboard", ExpiresAt: now.Add(time.Minute).Unix(), Scope: ScopeSearch, Fields: []string{"Name"}}
+	token, err := SignToken(tokenKey, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := VerifyToken(tokenKey, token, now)
+	if err != nil || got.Subject != "dashboard" || !got.HasScope(ScopeSearch) || got.HasScope(ScopeFilter) {
+		t.Fatalf("Unexpected claims %+v %v", got, err)
+	}
+	if !got.CanSee("Id") || !got.CanSee("Name") || got.CanSee("About") {
+		t.Errorf("Unexpected fields %v", got.Fields)
+	}
+
+	if _, err := VerifyToken(tokenKey, token, now.Add(time.Minute)); err != errTokenExpired {
+		t.Errorf("Expected expired token, got %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	noExp, _ := SignToken(tokenKey, TokenClaims{Scope: ScopeSearch})
+	for name, bad := range map[string]string{
+		"other key": mustSign(t, []byte("other"), claims),
+		"tampered":  parts[0] + "." + tokenEncoding.EncodeToString([]byte(`{"exp":9999999999,"scope":"users:search users:filter"}`)) + "." + parts[2],
+		"alg none":  tokenEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".",
+		"no exp":    noExp,
+		"parts":     parts[0] + "." + parts[1],
+		"signature": parts[0] + "." + parts[1] + ".!",
+		"payload":   signRaw(parts[0] + ".!"),
+		"json":      signRaw(parts[0] + "." + tokenEncoding.EncodeToString([]byte("[]"))),
+	} {
+		if _, err := VerifyToken(tokenKey, bad, now); err != errInvalidToken {
+			t.Errorf("%s: expected invalid token, got %v", name, err)
+		}
+	}
+}
+
+func mustSign(t *testing.T, key []byte, claims TokenClaims) string {
+	t.Helper()
+	token, err := SignToken(key, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func signRaw(signed string) string {
+	return signed + "." + tokenEncoding.EncodeToString(tokenMAC(tokenKey, signed))
+}
+
+func TestSearchServerSignedTokens(t *testing.T) {
+	srv := NewSearchServer(testSearchServer.users, []string{"1234"})
+	srv.TokenKey = tokenKey
+	exp := time.Now().Add(time.Minute).Unix()
+	full := mustSign(t, tokenKey, TokenClaims{ExpiresAt: exp, Scope: ScopeSearch + " " + ScopeFilter})
+	limited := mustSign(t, tokenKey, TokenClaims{ExpiresAt: exp, Scope: ScopeSearch + " " + ScopeFilter, Fields: []string{"Name", "Gender"}})
+
+	for _, c := range []struct {
+		token, query string
+		status       int
+		error        string
+	}{
+		{full, "limit=1&offset=0&filter=Age:gt:30&sort=Age:desc", http.StatusOK, ""},
+		{limited, "limit=1&offset=0&order_by=1&filter=Gender:eq:male", http.StatusOK, ""},
+		{"1234", "limit=1&offset=0&order_by=0&filter=Age:gt:30", http.StatusOK, ""},
+		{mustSign(t, tokenKey, TokenClaims{ExpiresAt: exp, Scope: ScopeSearch}), "limit=1&offset=0&filter=Age:gt:30", http.StatusForbidden, "MissingScope:" + ScopeFilter},
+		{mustSign(t, tokenKey, TokenClaims{ExpiresAt: exp}), "limit=1&offset=0", http.StatusForbidden, "MissingScope:" + ScopeSearch},
+		{limited, "limit=1&offset=0&query=a", http.StatusForbidden, "ForbiddenField:About"},
+		{limited, "limit=1&offset=0&order_field=Age&order_by=1", http.StatusForbidden, "ForbiddenField:Age"},
+		{limited, "limit=1&offset=0&sort=Id,Age", http.StatusForbidden, "ForbiddenField:Age"},
+		{limited, "limit=1&offset=0&fields=email", http.StatusForbidden, "ForbiddenField:Email"},
+		{mustSign(t, tokenKey, TokenClaims{ExpiresAt: time.Now().Add(-time.Minute).Unix(), Scope: ScopeSearch}), "limit=1&offset=0", http.StatusUnauthorized, "TokenExpired"},
+		{mustSign(t, []byte("other"), TokenClaims{ExpiresAt: exp, Scope: ScopeSearch}), "limit=1&offset=0", http.StatusUnauthorized, "InvalidToken"},
+		{"4321", "limit=1&offset=0", http.StatusUnauthorized, ""},
+	} {
+		w := serverRequest(srv, c.token, c.query)
+		errResp := SearchErrorResponse{}
+		json.Unmarshal(w.Body.Bytes(), &errResp)
+		if w.Code != c.status || errResp.Error != c.error {
+			t.Errorf("%s: expected %d %q, got %d %s", c.query, c.status, c.error, w.Code, w.Body)
+		}
+	}
+
+	// fields the token does not allow are cleared
+	w := serverRequest(srv, limited, "limit=3&offset=0&order_by=1")
+	users := []User{}
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil || len(users) != 3 {
+		t.Fatalf("Unexpected answer %s", w.Body)
+	}
+	for _, u := range users {
+		if u.Name == "" || u.Gender == "" || u.Age != 0 || u.About != "" {
+			t.Errorf("Expected only Id, Name and Gender, got %+v", u)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"hw4_test_coverage/server"
+)
+
+// TokenSource gives SearchClient the token of every request.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenInvalidator is a TokenSource caching its tokens. SearchClient
+// invalidates a token the server reports as expired, which happens when the
+// clocks differ, and retries the request once with a new one.
+type TokenInvalidator interface {
+	TokenSource
+	// InvalidateToken drops the token if it is still the cached one
+	InvalidateToken(token string)
+}
+
+// TokenFunc adapts a function to TokenSource
+type TokenFunc func(ctx context.Context) (string, error)
+
+func (f TokenFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// SignedTokenSource signs tokens with Claims, replacing a token when less
+// than a tenth of TTL is left. ExpiresAt of Claims is ignored.
+type SignedTokenSource struct {
+	Key    []byte
+	Claims server.TokenClaims
+	// TTL is 1 hour if zero
+	TTL time.Duration
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	now     func() time.Time
+}
+
+func (s *SignedTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if s.token != "" && now.Add(ttl/10).Before(s.expires) {
+		return s.token, nil
+	}
+
+	claims := s.Claims
+	claims.ExpiresAt = now.Add(ttl).Unix()
+	token, err := server.SignToken(s.Key, claims)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expires = token, time.Unix(claims.ExpiresAt, 0)
+	return token, nil
+}
+
+func (s *SignedTokenSource) InvalidateToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
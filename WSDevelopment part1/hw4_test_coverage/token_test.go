@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"hw4_test_coverage/server"
+)
+
+var tokenKey = []byte("secret")
+
+func TestSignedTokenSource(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	source := &SignedTokenSource{Key: tokenKey, Claims: server.TokenClaims{Scope: server.ScopeSearch}, TTL: 100 * time.Second, now: clock.Now}
+
+	first, _ := source.Token(context.Background())
+	clock.now = clock.now.Add(89 * time.Second)
+	if second, _ := source.Token(context.Background()); second != first {
+		t.Error("Expected the token to be reused")
+	}
+	clock.now = clock.now.Add(time.Second)
+	second, _ := source.Token(context.Background())
+	if second == first {
+		t.Error("Expected a new token before expiry")
+	}
+	claims, err := server.VerifyToken(tokenKey, second, clock.now)
+	if err != nil || claims.ExpiresAt != 1190 {
+		t.Errorf("Unexpected claims %+v %v", claims, err)
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	source.InvalidateToken(first)
+	if third, _ := source.Token(context.Background()); third != second {
+		t.Error("Expected an old token not to invalidate the current one")
+	}
+	source.InvalidateToken(second)
+	if third, _ := source.Token(context.Background()); third == second {
+		t.Error("Expected a new token after invalidation")
+	}
+}
+
+// skewedTokens hands out an expired token until it is invalidated
+type skewedTokens struct {
+	invalidated int
+}
+
+func (s *skewedTokens) Token(ctx context.Context) (string, error) {
+	exp := time.Now().Add(time.Minute)
+	if s.invalidated == 0 {
+		exp = time.Now().Add(-time.Minute)
+	}
+	return server.SignToken(tokenKey, server.TokenClaims{Scope: server.ScopeSearch, ExpiresAt: exp.Unix()})
+}
+
+func (s *skewedTokens) InvalidateToken(token string) {
+	s.invalidated++
+}
+
+func TestTokenExpiredRetry(t *testing.T) {
+	srv := server.NewSearchServer(testUsers, nil)
+	srv.TokenKey = tokenKey
+	counter := &countingTransport{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	tokens := &skewedTokens{}
+	cl := &SearchClient{URL: ts.URL, Tokens: tokens, Options: ClientOptions{HTTPClient: &http.Client{Transport: counter}}}
+	if _, err := cl.FindUsers(retryRequest); err != nil || tokens.invalidated != 1 || counter.requests != 2 {
+		t.Errorf("Expected one retry with a new token, got %d invalidations, %d requests: %v", tokens.invalidated, counter.requests, err)
+	}
+
+	// the server clock is ahead by more than the TTL, the new token expired too
+	lagging := &fakeClock{now: time.Now().Add(-time.Hour)}
+	counter.requests = 0
+	cl.Tokens = &SignedTokenSource{Key: tokenKey, Claims: server.TokenClaims{Scope: server.ScopeSearch}, TTL: time.Minute, now: lagging.Now}
+	if _, err := cl.FindUsers(retryRequest); err != ErrTokenExpired || counter.requests != 2 {
+		t.Errorf("Expected %v after %d requests, got %v after %d", ErrTokenExpired, 2, err, counter.requests)
+	}
+}
+
+func TestSignedTokens(t *testing.T) {
+	srv := server.NewSearchServer(testUsers, []string{"1234"})
+	srv.TokenKey = tokenKey
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	full := server.TokenClaims{Scope: server.ScopeSearch + " " + server.ScopeFilter}
+	limited := server.TokenClaims{Scope: server.ScopeSearch + " " + server.ScopeFilter, Fields: []string{"Name", "Gender"}}
+	expired := TokenFunc(func(ctx context.Context) (string, error) {
+		return server.SignToken(tokenKey, server.TokenClaims{Scope: server.ScopeSearch, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	})
+	filtered := SearchRequest{Limit: 1, Filters: []Filter{{Field: "Age", Op: FilterGreater, Value: "30"}}}
+
+	for _, c := range []struct {
+		name   string
+		tokens TokenSource
+		req    SearchRequest
+		err    error
+	}{
+		{"full", &SignedTokenSource{Key: tokenKey, Claims: full}, filtered, nil},
+		{"search only", &SignedTokenSource{Key: tokenKey, Claims: server.TokenClaims{Scope: server.ScopeSearch}}, filtered, &ForbiddenError{Scope: server.ScopeFilter}},
+		{"no scopes", &SignedTokenSource{Key: tokenKey}, retryRequest, &ForbiddenError{Scope: server.ScopeSearch}},
+		{"query", &SignedTokenSource{Key: tokenKey, Claims: limited}, retryRequest, &ForbiddenError{Field: "About"}},
+		{"order", &SignedTokenSource{Key: tokenKey, Claims: limited}, SearchRequest{OrderField: "Age", OrderBy: 1}, &ForbiddenError{Field: "Age"}},
+		{"sort", &SignedTokenSource{Key: tokenKey, Claims: limited}, SearchRequest{Sort: []SortKey{{Field: "Id"}, {Field: "Age"}}}, &ForbiddenError{Field: "Age"}},
+		{"filter", &SignedTokenSource{Key: tokenKey, Claims: limited}, filtered, &ForbiddenError{Field: "Age"}},
+		{"expired", expired, retryRequest, ErrTokenExpired},
+		{"invalid", &SignedTokenSource{Key: []byte("other"), Claims: full}, retryRequest, ErrInvalidToken},
+		{"unknown", TokenFunc(func(context.Context) (string, error) { return "4321", nil }), retryRequest, ErrUnauthorized},
+		{"static", TokenFunc(func(context.Context) (string, error) { return "1234", nil }), filtered, nil},
+	} {
+		cl := &SearchClient{URL: ts.URL, Tokens: c.tokens}
+		_, err := cl.FindUsers(c.req)
+		var forbidden *ForbiddenError
+		switch expected := c.err.(type) {
+		case nil:
+			if err != nil {
+				t.Errorf("[%s] Unexpected error %v", c.name, err)
+			}
+		case *ForbiddenError:
+			if !errors.As(err, &forbidden) || *forbidden != *expected {
+				t.Errorf("[%s] Expected %v, got %v", c.name, expected, err)
+			}
+		default:
+			if err != expected || !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("[%s] Expected %v, got %v", c.name, expected, err)
+			}
+		}
+	}
+
+	cl := &SearchClient{URL: ts.URL, Tokens: &SignedTokenSource{Key: tokenKey, Claims: limited}}
+	resp, err := cl.FindUsers(SearchRequest{Limit: 3, OrderField: "Name", OrderBy: 1, Filters: []Filter{{Field: "Gender", Op: FilterEquals, Value: "male"}}})
+	if err != nil || len(resp.Users) != 3 {
+		t.Fatalf("Unexpected result %v %v", resp, err)
+	}
+	for _, u := range resp.Users {
+		if u.Name == "" || u.Gender != "male" || u.Age != 0 || u.About != "" {
+			t.Errorf("Expected only Id, Name and Gender, got %+v", u)
+		}
+	}
+
+	sourceErr := errors.New("no token")
+	cl = &SearchClient{URL: ts.URL, Tokens: TokenFunc(func(context.Context) (string, error) { return "", sourceErr })}
+	if _, err := cl.FindUsers(retryRequest); !errors.Is(err, sourceErr) {
+		t.Errorf("Expected the token source error, got %v", err)
+	}
+}
+
+func TestForbiddenErrorMessages(t *testing.T) {
+	for err, expected := range map[*ForbiddenError]string{
+		{Scope: server.ScopeFilter}: "forbidden: missing scope users:filter",
+		{Field: "About"}:            "forbidden: field About",
+		{}:                          "forbidden",
+	} {
+		if err.Error() != expected {
+			t.Errorf("Expected %q, got %q", expected, err.Error())
+		}
+	}
+	ts := httptest.NewServer(statusHandler(403, `{"Error":"Whatever"}`))
+	defer ts.Close()
+	var forbidden *ForbiddenError
+	if _, err := (&SearchClient{URL: ts.URL}).FindUsers(retryRequest); !errors.As(err, &forbidden) || *forbidden != (ForbiddenError{}) {
+		t.Errorf("Unexpected error %v", err)
+	}
+}